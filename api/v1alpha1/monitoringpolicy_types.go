@@ -0,0 +1,115 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MonitoringPolicySpec selects objects and applies settings equivalent to the
+// tinymon.io/* annotations to them.
+type MonitoringPolicySpec struct {
+	// Kinds the policy applies to: Deployment, Ingress, PersistentVolumeClaim,
+	// Node or Schedule (K8up).
+	// +kubebuilder:validation:MinItems=1
+	Kinds []string `json:"kinds"`
+
+	// NamespaceSelector restricts the policy to objects in matching namespaces.
+	// Ignored for cluster-scoped kinds. Empty matches all namespaces.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Selector restricts the policy to objects with matching labels.
+	// Empty matches all objects.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Enabled is equivalent to tinymon.io/enabled.
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// NameTemplate is a Go template rendered into tinymon.io/name, e.g.
+	// "{{ .Namespace }}/{{ .Name }}".
+	// +optional
+	NameTemplate string `json:"nameTemplate,omitempty"`
+
+	// Topic is equivalent to tinymon.io/topic.
+	// +optional
+	Topic string `json:"topic,omitempty"`
+
+	// CheckInterval is equivalent to tinymon.io/check-interval.
+	// +kubebuilder:validation:Minimum=30
+	// +optional
+	CheckInterval int32 `json:"checkInterval,omitempty"`
+
+	// Labels are added as TinyMon host labels, equivalent to tinymon.io/label-<key>.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Thresholds per check type, equivalent to tinymon.io/threshold.<type>.
+	// +optional
+	Thresholds map[string]string `json:"thresholds,omitempty"`
+
+	// Priority orders policies matching the same object. Higher wins.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// Override makes the policy take precedence over annotations and labels on
+	// the object and its namespace. By default they win over the policy.
+	// +optional
+	Override bool `json:"override,omitempty"`
+}
+
+// MatchedObject references an object selected by a MonitoringPolicy.
+type MatchedObject struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// MonitoringPolicyStatus defines the observed state of MonitoringPolicy.
+type MonitoringPolicyStatus struct {
+	// ObservedGeneration is the generation the status was computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// MatchedCount is the total number of objects selected by the policy.
+	// +optional
+	MatchedCount int32 `json:"matchedCount"`
+
+	// MatchedObjects lists the selected objects, truncated to the first 100.
+	// +optional
+	MatchedObjects []MatchedObject `json:"matchedObjects,omitempty"`
+
+	// Conditions represent the latest available observations of the policy.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=mpol
+// +kubebuilder:printcolumn:name="Kinds",type=string,JSONPath=`.spec.kinds`
+// +kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matchedCount`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MonitoringPolicy enables and configures monitoring for selected objects
+// without annotating them.
+type MonitoringPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MonitoringPolicySpec   `json:"spec,omitempty"`
+	Status MonitoringPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MonitoringPolicyList contains a list of MonitoringPolicy.
+type MonitoringPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MonitoringPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MonitoringPolicy{}, &MonitoringPolicyList{})
+}
@@ -0,0 +1,117 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// CheckHost describes the TinyMon host the checks belong to.
+type CheckHost struct {
+	// Name is the display name in TinyMon. Defaults to the object name.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Address is the TinyMon host address. Defaults to
+	// k8s://<cluster>/check/<namespace>/<name>. It must start with
+	// k8s://<cluster>/check/<namespace>/ and not be used by another
+	// TinyMonCheck.
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// Description is shown in TinyMon.
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Topic groups the host in the TinyMon dashboard. Defaults to
+	// Kubernetes/<cluster>/checks/<namespace>.
+	// +optional
+	Topic string `json:"topic,omitempty"`
+
+	// Labels are added to the TinyMon host.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// CheckSpec describes a single TinyMon check executed by TinyMon.
+type CheckSpec struct {
+	// Type is the TinyMon check type, e.g. ping, tcp, dns or http.
+	// +kubebuilder:validation:Pattern=`^[a-z][a-z0-9_]*$`
+	Type string `json:"type"`
+
+	// Config is passed to TinyMon unchanged, e.g. {"host": "example.com", "port": 443}.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Type=object
+	// +optional
+	Config *runtime.RawExtension `json:"config,omitempty"`
+
+	// IntervalSeconds is the check interval. Defaults to 60.
+	// +kubebuilder:validation:Minimum=30
+	// +optional
+	IntervalSeconds int32 `json:"intervalSeconds,omitempty"`
+
+	// Enabled can be set to false to pause the check without removing it.
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+// TinyMonCheckSpec defines a TinyMon host and the checks TinyMon runs for it.
+type TinyMonCheckSpec struct {
+	// +optional
+	Host CheckHost `json:"host,omitempty"`
+
+	// +kubebuilder:validation:MinItems=1
+	Checks []CheckSpec `json:"checks"`
+}
+
+// TinyMonCheckStatus defines the observed state of TinyMonCheck.
+type TinyMonCheckStatus struct {
+	// ObservedGeneration is the generation last synced to TinyMon.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Address is the TinyMon host address the checks were synced to.
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// Checks are the check types currently synced to TinyMon.
+	// +optional
+	Checks []string `json:"checks,omitempty"`
+
+	// LastSyncTime is the time of the last successful sync.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// Conditions represent the latest available observations of the object.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=tmc
+// +kubebuilder:printcolumn:name="Address",type=string,JSONPath=`.status.address`
+// +kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.conditions[?(@.type=="Synced")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// TinyMonCheck declares a TinyMon host with arbitrary checks, e.g. for
+// external endpoints the operator doesn't discover on its own.
+type TinyMonCheck struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TinyMonCheckSpec   `json:"spec,omitempty"`
+	Status TinyMonCheckStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TinyMonCheckList contains a list of TinyMonCheck.
+type TinyMonCheckList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TinyMonCheck `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TinyMonCheck{}, &TinyMonCheckList{})
+}
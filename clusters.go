@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/controller"
+	"github.com/unclesamwk/tinymon-operator/internal/integration"
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	k8upv1 "github.com/k8up-io/k8up/v2/api/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	crcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+// clusterSetup is shared by the operator's own cluster and every remote
+// cluster.
+type clusterSetup struct {
+	tm       tinymon.API
+	tmClient *tinymon.Client
+	// opts are the options of the operator's own cluster.
+	opts controller.Options
+	// credentials enables Namespace credentials, read from the Secrets of
+	// each cluster. wrap is applied to their clients.
+	credentials bool
+	wrap        func(tinymon.API) tinymon.API
+	// retention enables deleting expired disabled hosts if positive.
+	retention     time.Duration
+	eventInterval time.Duration
+}
+
+// setupControllers adds the controllers, the optional integrations and the
+// deletion of expired disabled hosts of a cluster to mgr.
+func (s clusterSetup) setupControllers(mgr ctrl.Manager, opts controller.Options, clientset kubernetes.Interface) error {
+	// Core controllers — always available
+	if err := controller.SetupNodeReconciler(mgr, s.tm, opts, clientset); err != nil {
+		return fmt.Errorf("node controller: %w", err)
+	}
+	if err := controller.SetupDeploymentReconciler(mgr, s.tm, opts); err != nil {
+		return fmt.Errorf("deployment controller: %w", err)
+	}
+	if err := controller.SetupIngressReconciler(mgr, s.tm, opts); err != nil {
+		return fmt.Errorf("ingress controller: %w", err)
+	}
+	if err := controller.SetupPVCReconciler(mgr, s.tm, opts); err != nil {
+		return fmt.Errorf("pvc controller: %w", err)
+	}
+	if err := controller.SetupMonitoringPolicyReconciler(mgr, opts); err != nil {
+		return fmt.Errorf("monitoringpolicy controller: %w", err)
+	}
+	if err := controller.SetupTinyMonCheckReconciler(mgr, s.tm, opts); err != nil {
+		return fmt.Errorf("tinymoncheck controller: %w", err)
+	}
+
+	// Optional controllers — started and stopped as their CRDs are installed and removed
+	integrations := integration.NewRegistry(mgr)
+	integrations.Register(integration.Integration{
+		Name:          "k8up",
+		GroupVersions: []schema.GroupVersion{k8upv1.GroupVersion},
+		Objects:       []client.Object{&k8upv1.Schedule{}, &k8upv1.Backup{}},
+		New: func() (crcontroller.Controller, error) {
+			return controller.NewBackupController(mgr, s.tm, opts)
+		},
+	})
+	if err := mgr.Add(integrations); err != nil {
+		return fmt.Errorf("integrations: %w", err)
+	}
+
+	if s.retention > 0 {
+		reaper := &controller.HostReaper{
+			Client:      s.tmClient,
+			Credentials: opts.Credentials,
+			Shards:      opts.Shards,
+			Cluster:     opts.Cluster,
+			Retention:   s.retention,
+			Interval:    min(s.retention, time.Hour),
+			Disabled:    opts.Disabled,
+		}
+		if err := mgr.Add(reaper); err != nil {
+			return fmt.Errorf("deletion of disabled hosts: %w", err)
+		}
+	}
+	return nil
+}
+
+// newRemote builds the manager of a remote cluster with all controllers. It
+// serves no metrics or probes of its own, they are served by the operator's
+// manager.
+func (s clusterSetup) newRemote(cluster string, cfg *rest.Config) (manager.Manager, error) {
+	// The controllers of every cluster have the same names.
+	skipNameValidation := true
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:     scheme,
+		Metrics:    metricsserver.Options{BindAddress: "0"},
+		Controller: config.Controller{SkipNameValidation: &skipNameValidation},
+		Cache:      cache.Options{DefaultTransform: controller.CacheTransform},
+		Logger:     ctrl.Log.WithValues("cluster", cluster),
+	})
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := s.opts
+	opts.Cluster = cluster
+	opts.Events = controller.NewNotifier(mgr.GetEventRecorder("tinymon-operator"), s.eventInterval)
+	if s.credentials {
+		opts.Credentials = controller.NewCredentials(s.tmClient, mgr.GetAPIReader(), s.wrap)
+	}
+	if err := s.setupControllers(mgr, opts, clientset); err != nil {
+		return nil, err
+	}
+	return mgr, nil
+}
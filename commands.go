@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/controller"
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/yaml"
+)
+
+const commandUsage = `Usage: tinymon-operator [flags]            run the operator
+       tinymon-operator sync --once [--deletion-policy delete|disable|retain] [--namespace-credentials]
+                                            reconcile everything once and exit
+       tinymon-operator export [-o yaml]    print the hosts, checks and results the operator would create
+       tinymon-operator diff [--deletion-policy delete|disable|retain]
+                                            compare the desired state against TinyMon, exit 1 on differences
+       tinymon-operator gc [--dry-run] [--deletion-policy delete|disable|retain]
+                                            delete hosts of this cluster that no object asks for
+       tinymon-operator migrate --map old-prefix=new-prefix [--dry-run]
+                                            move hosts to new addresses, e.g. after renaming the cluster
+
+All commands read TINYMON_URL, TINYMON_API_KEY and CLUSTER_NAME like the operator;
+export only needs CLUSTER_NAME. sync, export, diff and gc take the operator's --config file.
+`
+
+// command is a subcommand of the binary. Without one, the operator runs.
+type command struct {
+	run func(ctx context.Context, env *commandEnv, args []string) error
+	// needs lists what the command talks to, TinyMon and/or Kubernetes.
+	tinymon    bool
+	kubernetes bool
+}
+
+var commands = map[string]command{
+	"sync":    {run: runSync, tinymon: true, kubernetes: true},
+	"export":  {run: runExport, kubernetes: true},
+	"diff":    {run: runDiff, tinymon: true, kubernetes: true},
+	"gc":      {run: runGC, tinymon: true, kubernetes: true},
+	"migrate": {run: runMigrate, tinymon: true},
+}
+
+// commandEnv holds the clients shared by all commands.
+type commandEnv struct {
+	cluster   string
+	tinymon   *tinymon.Client
+	k8s       client.Client
+	clientset kubernetes.Interface
+	out       io.Writer
+}
+
+// runCommand runs a subcommand and returns the exit code.
+func runCommand(name string, args []string) int {
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, commandUsage)
+		return 2
+	}
+
+	ctrl.SetLogger(zap.New())
+	env, err := newCommandEnv(cmd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cmd.run(ctrl.SetupSignalHandler(), env, args); err != nil {
+		if errors.Is(err, errDifferences) {
+			return 1
+		}
+		if errors.Is(err, flag.ErrHelp) {
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// newCommandEnv creates the clients cmd needs. The TinyMon settings are
+// only required if the command talks to TinyMon.
+func newCommandEnv(cmd command) (*commandEnv, error) {
+	env := &commandEnv{out: os.Stdout}
+	required := []string{"CLUSTER_NAME"}
+	if cmd.tinymon {
+		required = append(required, "TINYMON_URL", "TINYMON_API_KEY")
+	}
+	for _, v := range required {
+		if os.Getenv(v) == "" {
+			return nil, fmt.Errorf("%s environment variable is required", v)
+		}
+	}
+	env.cluster = os.Getenv("CLUSTER_NAME")
+	env.tinymon = tinymon.NewClient(os.Getenv("TINYMON_URL"), os.Getenv("TINYMON_API_KEY"))
+	if !cmd.kubernetes {
+		return env, nil
+	}
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if env.k8s, err = client.New(cfg, client.Options{Scheme: scheme}); err != nil {
+		return nil, err
+	}
+	if env.clientset, err = kubernetes.NewForConfig(cfg); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// configFlag adds the --config flag of the operator to fs.
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "Path of the operator's YAML file with templates and label mappings.")
+}
+
+// deletionPolicyFlag adds the --deletion-policy flag of the operator to fs.
+func deletionPolicyFlag(fs *flag.FlagSet) *string {
+	return fs.String("deletion-policy", "delete", "What happens to the host of an object that isn't monitored: delete, disable or retain.")
+}
+
+// options returns the controller options for this cluster with the --config
+// file at path applied.
+func (e *commandEnv) options(path string) (controller.Options, error) {
+	opts := controller.Options{Cluster: e.cluster}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return opts, err
+	}
+	return opts, cfg.apply(&opts)
+}
+
+// desiredState computes the hosts, checks and results without changing
+// anything in the cluster or in TinyMon. policyName is the deletion policy
+// of objects without the annotation, empty for the default.
+func (e *commandEnv) desiredState(ctx context.Context, configPath, policyName string) (tinymon.State, error) {
+	dry := tinymon.NewDryRun(ctrl.Log.WithName("dry-run").V(1))
+	opts, err := e.options(configPath)
+	if err != nil {
+		return tinymon.State{}, err
+	}
+	if policyName != "" {
+		if opts.DeletionPolicy, err = controller.ParseDeletionPolicy(policyName); err != nil {
+			return tinymon.State{}, err
+		}
+	}
+	if err := controller.RunOnce(ctx, client.NewDryRunClient(e.k8s), dry, opts, e.clientset); err != nil {
+		return tinymon.State{}, err
+	}
+	return dry.Snapshot(), nil
+}
+
+// actualState returns the hosts and checks in TinyMon that belong to this
+// cluster: hosts with an address of the cluster and hosts in desired, e.g.
+// of TinyMonChecks with a custom address.
+func (e *commandEnv) actualState(desired tinymon.State) (tinymon.State, error) {
+	hosts, err := e.tinymon.ListHosts()
+	if err != nil {
+		return tinymon.State{}, err
+	}
+	checks, err := e.tinymon.ListChecks()
+	if err != nil {
+		return tinymon.State{}, err
+	}
+
+	owned := make(map[string]bool)
+	for _, h := range desired.Hosts {
+		owned[h.Address] = true
+	}
+	prefix := "k8s://" + e.cluster + "/"
+	var st tinymon.State
+	for _, h := range hosts {
+		if strings.HasPrefix(h.Address, prefix) || owned[h.Address] {
+			owned[h.Address] = true
+			st.Hosts = append(st.Hosts, h)
+		}
+	}
+	for _, c := range checks {
+		if owned[c.HostAddress] {
+			st.Checks = append(st.Checks, c)
+		}
+	}
+	return st, nil
+}
+
+// keptHosts returns the addresses of the hosts in actual that no object asks
+// for but the deletion policy keeps: hosts kept by an object's policy and
+// hosts already disabled, which the operator deletes after the retention.
+func keptHosts(desired, actual tinymon.State) map[string]bool {
+	kept := make(map[string]bool, len(desired.Kept))
+	for _, addr := range desired.Kept {
+		kept[addr] = true
+	}
+	for _, h := range actual.Hosts {
+		if h.Labels[controller.LabelDisabledSince] != "" {
+			kept[h.Address] = true
+		}
+	}
+	for _, h := range desired.Hosts {
+		delete(kept, h.Address)
+	}
+	return kept
+}
+
+func runSync(ctx context.Context, e *commandEnv, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	once := fs.Bool("once", false, "Reconcile every object once and exit.")
+	writeStatus := fs.Bool("write-status", false, "Write sync status annotations back onto monitored objects.")
+	policyName := deletionPolicyFlag(fs)
+	namespaceCredentials := fs.Bool("namespace-credentials", false, "Send the objects of Namespaces annotated with tinymon.io/credentials-secret to the TinyMon in that Secret.")
+	configPath := configFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*once {
+		return errors.New("only --once is supported, run without a command for continuous syncing")
+	}
+	policy, err := controller.ParseDeletionPolicy(*policyName)
+	if err != nil {
+		return err
+	}
+	opts, err := e.options(*configPath)
+	if err != nil {
+		return err
+	}
+	opts.WriteStatus = *writeStatus
+	opts.DeletionPolicy = policy
+	opts.Disabled = controller.NewDisabledHosts(e.tinymon)
+	if *namespaceCredentials {
+		opts.Credentials = controller.NewCredentials(e.tinymon, e.k8s, nil)
+		opts.Disabled = controller.NewDisabledHosts(opts.Credentials)
+	}
+	return controller.RunOnce(ctx, e.k8s, e.tinymon, opts, e.clientset)
+}
+
+func runExport(ctx context.Context, e *commandEnv, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("o", "json", "Output format, json or yaml.")
+	configPath := configFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "json" && *output != "yaml" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	st, err := e.desiredState(ctx, *configPath, "")
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *output == "yaml" {
+		if data, err = yaml.JSONToYAML(data); err != nil {
+			return err
+		}
+	} else {
+		data = append(data, '\n')
+	}
+	_, err = e.out.Write(data)
+	return err
+}
+
+// errDifferences makes diff exit with 1 without printing an error.
+var errDifferences = errors.New("differences found")
+
+func runDiff(ctx context.Context, e *commandEnv, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	policyName := deletionPolicyFlag(fs)
+	configPath := configFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	desired, err := e.desiredState(ctx, *configPath, *policyName)
+	if err != nil {
+		return err
+	}
+	actual, err := e.actualState(desired)
+	if err != nil {
+		return err
+	}
+	desired.Kept = slices.Sorted(maps.Keys(keptHosts(desired, actual)))
+	ops := tinymon.Diff(desired, actual)
+	for _, op := range ops {
+		fmt.Fprintf(e.out, "%s %s", op.Action, op.Address)
+		if op.CheckType != "" {
+			fmt.Fprintf(e.out, " %s", op.CheckType)
+		}
+		fmt.Fprintln(e.out)
+		fields := make([]string, 0, len(op.Changes))
+		for field := range op.Changes {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			ch := op.Changes[field]
+			fmt.Fprintf(e.out, "    %s: %v -> %v\n", field, ch.Old, ch.New)
+		}
+	}
+	if len(ops) > 0 {
+		return errDifferences
+	}
+	return nil
+}
+
+func runGC(ctx context.Context, e *commandEnv, args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Only print the hosts that would be deleted.")
+	policyName := deletionPolicyFlag(fs)
+	configPath := configFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	policy, err := controller.ParseDeletionPolicy(*policyName)
+	if err != nil {
+		return err
+	}
+	if policy == controller.DeletionPolicyRetain {
+		fmt.Fprintln(e.out, "deletion policy is retain, keeping all hosts")
+		return nil
+	}
+
+	// An incomplete desired state would delete hosts that are still
+	// wanted, so any error aborts.
+	desired, err := e.desiredState(ctx, *configPath, *policyName)
+	if err != nil {
+		return err
+	}
+	actual, err := e.actualState(desired)
+	if err != nil {
+		return err
+	}
+	wanted := keptHosts(desired, actual)
+	for _, h := range desired.Hosts {
+		wanted[h.Address] = true
+	}
+	for _, h := range actual.Hosts {
+		if wanted[h.Address] {
+			continue
+		}
+		if err := e.removeHost(h, policy, *dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeHost deletes or, with the disable policy, disables the host h, which
+// the operator then deletes after the retention.
+func (e *commandEnv) removeHost(h tinymon.Host, policy controller.DeletionPolicy, dryRun bool) error {
+	action := "delete"
+	if policy == controller.DeletionPolicyDisable {
+		action = "disable"
+	}
+	if dryRun {
+		fmt.Fprintf(e.out, "would %s %s\n", action, h.Address)
+		return nil
+	}
+	if policy == controller.DeletionPolicyDisable {
+		labels := maps.Clone(h.Labels)
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[controller.LabelDisabledSince] = time.Now().UTC().Format(time.RFC3339)
+		h.Labels = labels
+		h.Enabled = 0
+		if err := e.tinymon.UpsertHost(h); err != nil {
+			return err
+		}
+	} else if err := e.tinymon.DeleteHost(h.Address); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "%sd %s\n", action, h.Address)
+	return nil
+}
+
+// mappingFlags collects repeated --map flags.
+type mappingFlags []tinymon.AddressMapping
+
+func (m *mappingFlags) String() string {
+	parts := make([]string, 0, len(*m))
+	for _, mapping := range *m {
+		parts = append(parts, mapping.Old+"="+mapping.New)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *mappingFlags) Set(s string) error {
+	mapping, err := tinymon.ParseAddressMapping(s)
+	if err != nil {
+		return err
+	}
+	*m = append(*m, mapping)
+	return nil
+}
+
+func runMigrate(_ context.Context, e *commandEnv, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	var mappings mappingFlags
+	fs.Var(&mappings, "map", "Address prefix mapping old=new, e.g. k8s://old-cluster/=k8s://new-cluster/ (repeatable).")
+	dryRun := fs.Bool("dry-run", false, "Only print the hosts that would be moved.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(mappings) == 0 {
+		return errors.New("at least one --map is required")
+	}
+
+	hosts, err := e.tinymon.ListHosts()
+	if err != nil {
+		return err
+	}
+	checks, err := e.tinymon.ListChecks()
+	if err != nil {
+		return err
+	}
+	migrations := tinymon.PlanMigrations(hosts, checks, mappings)
+	// TinyMon can't rename hosts, so the old host and its results are
+	// deleted after the copy.
+	for _, m := range migrations {
+		if *dryRun {
+			fmt.Fprintf(e.out, "would move %s -> %s (%d checks), deleting the result history of %s\n", m.From, m.Host.Address, len(m.Checks), m.From)
+			continue
+		}
+		if err := tinymon.Migrate(e.tinymon, m); err != nil {
+			return fmt.Errorf("move %s -> %s: %w", m.From, m.Host.Address, err)
+		}
+		fmt.Fprintf(e.out, "moved %s -> %s (%d checks), deleted the result history of %s\n", m.From, m.Host.Address, len(m.Checks), m.From)
+	}
+	if len(migrations) == 0 {
+		fmt.Fprintln(e.out, "no hosts to move")
+	} else {
+		fmt.Fprintln(e.out, "the moved hosts start without result history, TinyMon can't rename hosts")
+	}
+	return nil
+}
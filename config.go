@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/unclesamwk/tinymon-operator/internal/controller"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// operatorConfig is the file passed with --config.
+type operatorConfig struct {
+	// Templates are the name, description and message templates per kind.
+	Templates map[string]controller.TemplateConfig `json:"templates,omitempty"`
+	// Labels copy Kubernetes metadata to TinyMon host labels.
+	Labels []controller.LabelMapping `json:"labels,omitempty"`
+	// Hysteresis holds back status changes until they are confirmed.
+	Hysteresis controller.HysteresisConfig `json:"hysteresis,omitempty"`
+	// Heartbeat pushes unchanged results only this often, changed ones are
+	// pushed right away. Unset pushes every result.
+	Heartbeat metav1.Duration `json:"heartbeat,omitempty"`
+}
+
+// loadConfig reads the --config file. Without one, the built-in defaults
+// are used.
+func loadConfig(path string) (operatorConfig, error) {
+	var cfg operatorConfig
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// apply sets the controller options configured in the file.
+func (c operatorConfig) apply(opts *controller.Options) error {
+	templates, err := controller.NewTemplates(c.Templates)
+	if err != nil {
+		return err
+	}
+	opts.Templates = templates
+	if opts.LabelMappings, err = controller.NewLabelMapper(c.Labels); err != nil {
+		return err
+	}
+	if opts.Hysteresis, err = controller.NewHysteresis(c.Hysteresis); err != nil {
+		return err
+	}
+	if c.Heartbeat.Duration < 0 {
+		return fmt.Errorf("heartbeat: %s is negative", c.Heartbeat.Duration)
+	}
+	opts.Heartbeat = controller.NewHeartbeat(c.Heartbeat.Duration)
+	return nil
+}
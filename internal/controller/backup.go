@@ -6,25 +6,53 @@ import (
 	"sort"
 	"time"
 
+	tinymonv1alpha1 "github.com/unclesamwk/tinymon-operator/api/v1alpha1"
 	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
 
 	k8upv1 "github.com/k8up-io/k8up/v2/api/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 type BackupReconciler struct {
 	client.Client
-	TinyMon *tinymon.Client
-	Cluster string
+	TinyMon tinymon.API
+	Options
 }
 
-func SetupBackupReconciler(mgr ctrl.Manager, tm *tinymon.Client, cluster string) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&k8upv1.Schedule{}).
-		Complete(&BackupReconciler{Client: mgr.GetClient(), TinyMon: tm, Cluster: cluster})
+// NewBackupController builds the K8up Schedule controller without adding it
+// to the manager, so it can be started and stopped with the K8up CRDs.
+func NewBackupController(mgr ctrl.Manager, tm tinymon.API, opts Options) (controller.Controller, error) {
+	r := &BackupReconciler{Client: mgr.GetClient(), TinyMon: tm, Options: opts}
+	// The controller is created again every time the CRDs reappear.
+	skipNameValidation := true
+	c, err := controller.NewUnmanaged("backup", controller.Options{
+		Reconciler:         opts.reconciler("backup", r),
+		Logger:             mgr.GetLogger().WithValues("controller", "backup"),
+		SkipNameValidation: &skipNameValidation,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sources := []source.Source{
+		source.Kind[client.Object](mgr.GetCache(), &k8upv1.Schedule{}, &handler.EnqueueRequestForObject{}, ignoreStatusAnnotations),
+		source.Kind[client.Object](mgr.GetCache(), &corev1.Namespace{}, namespaceHandler(mgr.GetClient(), &k8upv1.ScheduleList{}), namespaceDefaultsChanged),
+		source.Kind[client.Object](mgr.GetCache(), &tinymonv1alpha1.MonitoringPolicy{}, policyHandler(mgr.GetClient(), &k8upv1.ScheduleList{}), predicate.GenerationChangedPredicate{}),
+		opts.rebalanceSource(mgr.GetClient(), &k8upv1.ScheduleList{}),
+	}
+	for _, src := range sources {
+		if err := c.Watch(src); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
 func (r *BackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -33,47 +61,95 @@ func (r *BackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	var schedule k8upv1.Schedule
 	if err := r.Get(ctx, req.NamespacedName, &schedule); err != nil {
 		if errors.IsNotFound(err) {
-			log.Info("K8up Schedule deleted, removing from TinyMon")
+			policy := r.deletionPolicy(nil)
+			log.Info("K8up Schedule deleted, removing from TinyMon", "deletionPolicy", policy)
 			addr := resourceAddress(r.Cluster, "backup", req.Namespace, req.Name)
-			_ = r.TinyMon.DeleteHost(addr)
+			api, err := r.tinymon(ctx, r.Client, r.TinyMon, req.Namespace)
+			if err != nil {
+				log.Error(err, "failed to resolve TinyMon credentials")
+				return ctrl.Result{}, err
+			}
+			host := r.deletedHost(addr, req.Name, fmt.Sprintf("K8up Schedule %s/%s", req.Namespace, req.Name), "backups", req.Namespace, "backup")
+			if err := r.removeHost(api, policy, host); err != nil {
+				log.Error(err, "failed to remove host")
+				return ctrl.Result{}, err
+			}
+			r.reportRemoved(ctx, r.Client, nil, addr, policy)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
 
-	if !isEnabled(schedule.Annotations) {
-		addr := resourceAddress(r.Cluster, "backup", schedule.Namespace, schedule.Name)
-		_ = r.TinyMon.DeleteHost(addr)
-		return ctrl.Result{}, nil
+	annotations, labels, err := effectiveMetadata(ctx, r.Client, KindSchedule, &schedule)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
 	addr := resourceAddress(r.Cluster, "backup", schedule.Namespace, schedule.Name)
-	interval := checkInterval(schedule.Annotations, 60)
-	t := defaultTopic(r.Cluster, "backups", schedule.Namespace, schedule.Annotations)
+	api, err := r.tinymon(ctx, r.Client, r.TinyMon, schedule.Namespace)
+	if err != nil {
+		log.Error(err, "failed to resolve TinyMon credentials")
+		r.reportFailed(ctx, r.Client, KindSchedule, &schedule, addr, err)
+		return ctrl.Result{}, err
+	}
+	interval := checkInterval(annotations, 60)
 
+	data := r.templateData(ctx, r.Client, KindSchedule, &schedule, annotations, scheduleValues(&schedule))
 	host := tinymon.Host{
-		Name:        displayName(schedule.Annotations, schedule.Name),
+		Name:        r.hostName(ctx, annotations, data),
 		Address:     addr,
-		Description: fmt.Sprintf("K8up Schedule %s/%s", schedule.Namespace, schedule.Name),
-		Topic:       t,
-		Labels:      buildLabels(r.Cluster, "backup", schedule.Labels),
+		Description: r.hostDescription(ctx, annotations, data, fmt.Sprintf("K8up Schedule %s/%s", schedule.Namespace, schedule.Name)),
+		Topic:       r.hostTopic(ctx, annotations, data, defaultTopic(r.Cluster, "backups", schedule.Namespace, annotations)),
+		Labels:      r.hostLabels("backup", &schedule, data, labels),
 		Enabled:     1,
 	}
 
+	if !isEnabled(annotations) {
+		policy := r.deletionPolicy(annotations)
+		if err := r.removeHost(api, policy, host); err != nil {
+			log.Error(err, "failed to remove host")
+			return ctrl.Result{}, err
+		}
+		r.reportRemoved(ctx, r.Client, &schedule, addr, policy)
+		return ctrl.Result{}, nil
+	}
+
+	r.reportAnnotations(KindSchedule, &schedule, annotations)
+
+	now := time.Now()
+	maint := maintenanceState(annotations, now)
+	tm := maint.api(api)
+	if maint.active() {
+		log.Info("in maintenance, checks disabled", "until", maint.until)
+	}
+
 	log.Info("syncing K8up Schedule to TinyMon", "address", addr)
-	if err := r.TinyMon.UpsertHost(host); err != nil {
+	if err := tm.UpsertHost(host); err != nil {
 		log.Error(err, "failed to upsert host")
+		r.reportFailed(ctx, r.Client, KindSchedule, &schedule, addr, err)
+		return ctrl.Result{}, err
+	}
+
+	checks := selectedChecks(annotations)
+	if err := r.removeDeselectedChecks(tm, addr, []string{"status"}, checks); err != nil {
+		log.Error(err, "failed to delete deselected checks")
+		r.reportFailed(ctx, r.Client, KindSchedule, &schedule, addr, err)
 		return ctrl.Result{}, err
 	}
+	if !checks.enabled("status") {
+		r.reportSynced(ctx, r.Client, KindSchedule, &schedule, addr, nil)
+		return ctrl.Result{RequeueAfter: maint.requeue(time.Duration(interval)*time.Second, now)}, nil
+	}
 
 	check := tinymon.Check{
 		HostAddress:     addr,
 		Type:            "status",
-		IntervalSeconds: interval,
+		IntervalSeconds: r.Heartbeat.interval(interval),
 		Enabled:         1,
 	}
-	if err := r.TinyMon.UpsertCheck(check); err != nil {
+	if err := tm.UpsertCheck(check); err != nil {
 		log.Error(err, "failed to upsert check")
+		r.reportFailed(ctx, r.Client, KindSchedule, &schedule, addr, err)
 		return ctrl.Result{}, err
 	}
 
@@ -87,27 +163,47 @@ func (r *BackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 			Status:      "unknown",
 			Message:     "Failed to list backup objects",
 		}}
-		_ = r.TinyMon.PushBulk(results)
+		_ = tm.PushBulk(results)
+		r.Heartbeat.forget(addr)
 		return ctrl.Result{}, err
 	}
 
-	status, msg, ageSec := lastBackupStatus(backupList.Items)
-	results := []tinymon.Result{{
+	status, msg, ageSec := lastBackupStatus(backupList.Items, annotations, r.Hysteresis, addr)
+	results := r.Hysteresis.stabilize(annotations, r.renderMessages(ctx, annotations, data, []tinymon.Result{{
 		HostAddress: addr,
 		CheckType:   "status",
 		Status:      status,
 		Value:       ageSec,
 		Message:     msg,
-	}}
-	if err := r.TinyMon.PushBulk(results); err != nil {
-		log.Error(err, "failed to push bulk results")
-		return ctrl.Result{}, err
+	}}))
+	if pending := r.Heartbeat.due(results, now, time.Duration(interval)*time.Second); len(pending) > 0 {
+		if err := tm.PushBulk(pending); err != nil {
+			log.Error(err, "failed to push bulk results")
+			r.reportFailed(ctx, r.Client, KindSchedule, &schedule, addr, err)
+			return ctrl.Result{}, err
+		}
+		r.Heartbeat.pushed(addr, maint, pending, now)
 	}
 
-	return ctrl.Result{RequeueAfter: time.Duration(interval) * time.Second}, nil
+	r.reportSynced(ctx, r.Client, KindSchedule, &schedule, addr, maint.results(results))
+
+	return ctrl.Result{RequeueAfter: maint.requeue(time.Duration(interval)*time.Second, now)}, nil
+}
+
+// scheduleValues are the computed template values of a K8up Schedule.
+func scheduleValues(schedule *k8upv1.Schedule) map[string]any {
+	backupSchedule := ""
+	if b := schedule.Spec.Backup; b != nil && b.ScheduleCommon != nil {
+		backupSchedule = string(b.Schedule)
+	}
+	return map[string]any{"BackupSchedule": backupSchedule}
 }
 
-func lastBackupStatus(backups []k8upv1.Backup) (string, string, float64) {
+// lastBackupStatus rates the newest backup. Its age is rated against the
+// tinymon.io/threshold.backup-age annotation in hours, by default a backup
+// older than 48 hours is a warning. h applies the exit thresholds to the
+// status check of the host at addr.
+func lastBackupStatus(backups []k8upv1.Backup, annotations map[string]string, h *Hysteresis, addr string) (string, string, float64) {
 	if len(backups) == 0 {
 		return "warning", "No backups found", 0
 	}
@@ -121,12 +217,13 @@ func lastBackupStatus(backups []k8upv1.Backup) (string, string, float64) {
 	age := time.Since(latest.CreationTimestamp.Time)
 	ageSec := age.Seconds()
 	ageStr := formatDuration(age)
+	ageStatus := h.thresholdStatus(annotations, addr, "status", "backup-age", age.Hours())
 
 	// Check conditions for completion/failure
 	for _, cond := range latest.Status.Conditions {
 		if cond.Type == "Completed" && cond.Status == "True" {
-			if age > 48*time.Hour {
-				return "warning", fmt.Sprintf("Last backup completed %s ago (stale)", ageStr), ageSec
+			if ageStatus != "ok" {
+				return ageStatus, fmt.Sprintf("Last backup completed %s ago (stale)", ageStr), ageSec
 			}
 			return "ok", fmt.Sprintf("Last backup completed %s ago", ageStr), ageSec
 		}
@@ -139,8 +236,8 @@ func lastBackupStatus(backups []k8upv1.Backup) (string, string, float64) {
 	if age < 2*time.Hour {
 		return "ok", fmt.Sprintf("Backup in progress (%s ago)", ageStr), ageSec
 	}
-	if age > 48*time.Hour {
-		return "warning", fmt.Sprintf("No recent backup (last: %s ago)", ageStr), ageSec
+	if ageStatus != "ok" {
+		return ageStatus, fmt.Sprintf("No recent backup (last: %s ago)", ageStr), ageSec
 	}
 
 	return "ok", fmt.Sprintf("Last backup: %s ago", ageStr), ageSec
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// annotationLastApplied is written by kubectl apply and holds a copy of the
+// whole object.
+const annotationLastApplied = "kubectl.kubernetes.io/last-applied-configuration"
+
+// CacheTransform strips the fields no controller reads from objects before
+// they are cached: managed fields, which are kept by updates without them,
+// and of the monitored kinds, which are only ever patched, the last applied
+// configuration, the Pod template of Deployments and the images of Nodes.
+// Objects the operator updates in full, like TinyMonChecks, keep their last
+// applied configuration, an update without it would remove it.
+func CacheTransform(obj any) (any, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return obj, nil
+	}
+	m.SetManagedFields(nil)
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		o.Spec.Template = corev1.PodTemplateSpec{}
+	case *corev1.Node:
+		o.Status.Images = nil
+	case *corev1.PersistentVolumeClaim, *networkingv1.Ingress, *metav1.PartialObjectMetadata:
+	default:
+		return obj, nil
+	}
+	if annotations := m.GetAnnotations(); annotations[annotationLastApplied] != "" {
+		delete(annotations, annotationLastApplied)
+		m.SetAnnotations(annotations)
+	}
+	return obj, nil
+}
+
+// metadataOnly reports whether obj is watched metadata-only with
+// Options.MetadataOnly. These are the kinds found in large numbers.
+func (o Options) metadataOnly(obj runtime.Object) bool {
+	if !o.MetadataOnly {
+		return false
+	}
+	switch obj.(type) {
+	case *appsv1.Deployment, *appsv1.DeploymentList,
+		*corev1.PersistentVolumeClaim, *corev1.PersistentVolumeClaimList,
+		*networkingv1.Ingress, *networkingv1.IngressList:
+		return true
+	}
+	return false
+}
+
+// forOptions returns the options of a controller's watch of obj.
+func (o Options) forOptions(obj client.Object) []builder.ForOption {
+	if o.metadataOnly(obj) {
+		return []builder.ForOption{builder.OnlyMetadata, builder.WithPredicates(ignoreStatusAnnotationWrites)}
+	}
+	return []builder.ForOption{builder.WithPredicates(ignoreStatusAnnotations)}
+}
+
+// listType returns the list to list objects of the type of list with, a
+// metadata-only list for the kinds watched metadata-only, so listing doesn't
+// start a full watch.
+func (o Options) listType(scheme *runtime.Scheme, list client.ObjectList) client.ObjectList {
+	if !o.metadataOnly(list) {
+		return list
+	}
+	return metadataList(scheme, list)
+}
+
+// metadataList returns a metadata-only list of the objects of the type of
+// list.
+func metadataList(scheme *runtime.Scheme, list client.ObjectList) client.ObjectList {
+	gvk, err := apiutil.GVKForObject(list, scheme)
+	if err != nil {
+		return list
+	}
+	m := &metav1.PartialObjectMetadataList{}
+	m.SetGroupVersionKind(gvk)
+	return m
+}
+
+// getObject reads the object at key into obj. For the kinds watched
+// metadata-only, the metadata is read from the cache, and the object is
+// only read in full from the API server if it is synced or disabled by the
+// disable deletion policy. Otherwise only the metadata is needed to remove
+// its host, and obj is left with it.
+func (o Options) getObject(ctx context.Context, c client.Client, reader client.Reader, kind string, key types.NamespacedName, obj client.Object) error {
+	if !o.metadataOnly(obj) {
+		return c.Get(ctx, key, obj)
+	}
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return err
+	}
+	m := &metav1.PartialObjectMetadata{}
+	m.SetGroupVersionKind(gvk)
+	if err := c.Get(ctx, key, m); err != nil {
+		return err
+	}
+	annotations, _, err := effectiveMetadata(ctx, c, kind, m)
+	if err != nil {
+		return err
+	}
+	if isEnabled(annotations) || o.deletionPolicy(annotations) == DeletionPolicyDisable {
+		if err := reader.Get(ctx, key, obj); err != nil {
+			return err
+		}
+		// The same as a cached object.
+		_, err := CacheTransform(obj)
+		return err
+	}
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(m)
+	if err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u, obj)
+}
@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+)
+
+// AnnotationChecks selects the checks created for an object, e.g.
+// "http,certificate" to create only these, or "-certificate" to create all
+// but the certificate check.
+const AnnotationChecks = "tinymon.io/checks"
+
+// knownCheckTypes are the check types created by the annotation-driven
+// controllers.
+var knownCheckTypes = map[string]bool{
+	"status":            true,
+	"http":              true,
+	"certificate":       true,
+	"icecast_listeners": true,
+	"load":              true,
+	"memory":            true,
+	"disk":              true,
+}
+
+// checkSelection is the parsed tinymon.io/checks annotation.
+type checkSelection struct {
+	include map[string]bool // nil: all types
+	exclude map[string]bool
+}
+
+// selectedChecks parses the tinymon.io/checks annotation. Unknown types are
+// ignored, so a typo doesn't remove every check.
+func selectedChecks(annotations map[string]string) checkSelection {
+	sel, _ := parseCheckSelection(annotations[AnnotationChecks])
+	return sel
+}
+
+func parseCheckSelection(v string) (checkSelection, error) {
+	var sel checkSelection
+	var unknown []string
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, excluded := strings.CutPrefix(entry, "-")
+		if !knownCheckTypes[name] {
+			unknown = append(unknown, name)
+			continue
+		}
+		if excluded {
+			if sel.exclude == nil {
+				sel.exclude = make(map[string]bool)
+			}
+			sel.exclude[name] = true
+		} else {
+			if sel.include == nil {
+				sel.include = make(map[string]bool)
+			}
+			sel.include[name] = true
+		}
+	}
+	if len(unknown) > 0 {
+		return sel, fmt.Errorf("unknown check types %s", strings.Join(unknown, ", "))
+	}
+	return sel, nil
+}
+
+// enabled reports whether checks of checkType are created.
+func (s checkSelection) enabled(checkType string) bool {
+	if s.exclude[checkType] {
+		return false
+	}
+	return s.include == nil || s.include[checkType]
+}
+
+// filterResults drops the results of checks that aren't enabled.
+func (s checkSelection) filterResults(results []tinymon.Result) []tinymon.Result {
+	var out []tinymon.Result
+	for _, res := range results {
+		if s.enabled(res.CheckType) {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+// RemovedChecks remembers the checks deleted because they were deselected,
+// so they are deleted once per host instead of on every reconcile. A nil
+// RemovedChecks deletes them on every reconcile.
+type RemovedChecks struct {
+	mu      sync.Mutex
+	removed map[checkKey]bool
+}
+
+func NewRemovedChecks() *RemovedChecks {
+	return &RemovedChecks{removed: make(map[checkKey]bool)}
+}
+
+func (r *RemovedChecks) done(key checkKey) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.removed[key]
+}
+
+func (r *RemovedChecks) set(key checkKey, removed bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if removed {
+		r.removed[key] = true
+	} else {
+		delete(r.removed, key)
+	}
+}
+
+// forget drops the removed checks of the host at addr, which was removed or
+// is synced by another replica now.
+func (r *RemovedChecks) forget(addr string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key := range r.removed {
+		if key.address == addr {
+			delete(r.removed, key)
+		}
+	}
+}
+
+// removeDeselectedChecks deletes the checks of the given types that s
+// doesn't enable from the host at addr, along with their status series.
+func (o Options) removeDeselectedChecks(tm tinymon.API, addr string, checkTypes []string, s checkSelection) error {
+	var lastErr error
+	for _, checkType := range checkTypes {
+		key := checkKey{addr, checkType}
+		if s.enabled(checkType) {
+			o.RemovedChecks.set(key, false)
+			continue
+		}
+		recordDeselected(addr, checkType)
+		if o.RemovedChecks.done(key) {
+			continue
+		}
+		if err := tm.DeleteCheck(addr, checkType); err != nil {
+			lastErr = err
+			continue
+		}
+		o.RemovedChecks.set(key, true)
+	}
+	return lastErr
+}
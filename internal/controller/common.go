@@ -1,22 +1,101 @@
 package controller
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/debug"
+	"github.com/unclesamwk/tinymon-operator/internal/health"
+	"github.com/unclesamwk/tinymon-operator/internal/shard"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 const (
-	AnnotationEnabled       = "tinymon.io/enabled"
-	AnnotationName          = "tinymon.io/name"
-	AnnotationTopic         = "tinymon.io/topic"
-	AnnotationCheckInterval = "tinymon.io/check-interval"
+	AnnotationPrefix = "tinymon.io/"
+
+	AnnotationEnabled        = "tinymon.io/enabled"
+	AnnotationName           = "tinymon.io/name"
+	AnnotationTopic          = "tinymon.io/topic"
+	AnnotationCheckInterval  = "tinymon.io/check-interval"
 	AnnotationExpectedStatus = "tinymon.io/expected-status"
 	AnnotationIcecastMounts  = "tinymon.io/icecast-mounts"
 	AnnotationHTTPPath       = "tinymon.io/http-path"
 
+	AnnotationThresholdPrefix = "tinymon.io/threshold."
+
 	LabelPrefix = "tinymon.io/label-"
 )
 
+// Options holds the settings shared by all controllers.
+type Options struct {
+	// Cluster is used in host addresses, topics and labels.
+	Cluster string
+	// Events emits Kubernetes Events on monitored objects.
+	Events *Notifier
+	// WriteStatus enables writing the tinymon.io/address, last-sync,
+	// last-status and sync-error annotations back onto monitored objects.
+	WriteStatus bool
+	// DryRun is set when TinyMon is a tinymon.DryRun. Syncs are then
+	// reported as dry-run instead of as synced, and not recorded in the
+	// metrics.
+	DryRun bool
+	// Workers tracks in-flight reconciles for the liveness check.
+	Workers *health.Workers
+	// Debug keeps the state of managed hosts for the debug endpoint.
+	Debug *debug.State
+	// DeletionPolicy applies to objects without the
+	// tinymon.io/deletion-policy annotation and to deleted objects. Empty
+	// means delete.
+	DeletionPolicy DeletionPolicy
+	// Disabled tracks the hosts disabled by the disable deletion policy.
+	// Without it, disable keeps hosts like retain.
+	Disabled *DisabledHosts
+	// Templates are the name, description, topic and message templates per
+	// kind.
+	Templates *Templates
+	// LabelMappings copy Kubernetes metadata to TinyMon host labels.
+	LabelMappings *LabelMapper
+	// Hysteresis holds back status changes until they are confirmed and
+	// applies exit thresholds.
+	Hysteresis *Hysteresis
+	// Heartbeat pushes unchanged results only once per heartbeat period.
+	Heartbeat *Heartbeat
+	// RemovedChecks remembers the deselected checks already deleted.
+	RemovedChecks *RemovedChecks
+	// Credentials routes objects in Namespaces with their own TinyMon
+	// credentials to their TinyMon.
+	Credentials *Credentials
+	// Shards assigns the objects to the operator replicas. Without it, this
+	// replica reconciles every object.
+	Shards *shard.Ring
+	// MetadataOnly watches Deployments, PVCs and Ingresses metadata-only and
+	// reads the synced ones in full from the API server.
+	MetadataOnly bool
+}
+
+// reconciler wraps r so it only reconciles this replica's shard, stuck
+// reconciles are detected by the liveness check and the next reconcile of
+// every host shows up on the debug endpoint.
+func (o Options) reconciler(name string, r reconcile.Reconciler) reconcile.Reconciler {
+	r = o.sharded(r)
+	if o.Debug != nil {
+		r = o.trackSchedule(r)
+	}
+	if o.Workers == nil {
+		return r
+	}
+	return o.Workers.Wrap(name, r)
+}
+
 func resourceAddress(cluster, kind, namespace, name string) string {
 	if namespace == "" {
 		return "k8s://" + cluster + "/" + kind + "/" + name
@@ -41,13 +120,6 @@ func isEnabled(annotations map[string]string) bool {
 	return annotations[AnnotationEnabled] == "true"
 }
 
-func displayName(annotations map[string]string, fallback string) string {
-	if annotations != nil && annotations[AnnotationName] != "" {
-		return annotations[AnnotationName]
-	}
-	return fallback
-}
-
 func checkInterval(annotations map[string]string, defaultInterval int) int {
 	if annotations == nil {
 		return defaultInterval
@@ -60,6 +132,177 @@ func checkInterval(annotations map[string]string, defaultInterval int) int {
 	return defaultInterval
 }
 
+// validateAnnotations returns an error for every tinymon.io annotation whose
+// value is invalid and therefore ignored.
+func validateAnnotations(annotations map[string]string) map[string]error {
+	errs := make(map[string]error)
+	if v, ok := annotations[AnnotationCheckInterval]; ok {
+		if i, err := strconv.Atoi(v); err != nil {
+			errs[AnnotationCheckInterval] = fmt.Errorf("%q is not a number of seconds", v)
+		} else if i < 30 {
+			errs[AnnotationCheckInterval] = fmt.Errorf("%d is below the minimum of 30 seconds", i)
+		}
+	}
+	if v, ok := annotations[AnnotationExpectedStatus]; ok {
+		if i, err := strconv.Atoi(v); err != nil || i < 100 || i >= 600 {
+			errs[AnnotationExpectedStatus] = fmt.Errorf("%q is not an HTTP status code", v)
+		}
+	}
+	if v, ok := annotations[AnnotationMaintenanceUntil]; ok {
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			errs[AnnotationMaintenanceUntil] = fmt.Errorf("%q is not an RFC3339 time, e.g. 2024-05-01T06:00:00Z", v)
+		}
+	}
+	if v, ok := annotations[AnnotationMaintenanceWindow]; ok {
+		if _, err := parseMaintenanceWindows(v); err != nil {
+			errs[AnnotationMaintenanceWindow] = err
+		}
+	}
+	if v, ok := annotations[AnnotationDeletionPolicy]; ok {
+		if _, err := ParseDeletionPolicy(v); err != nil {
+			errs[AnnotationDeletionPolicy] = err
+		}
+	}
+	for _, key := range []string{AnnotationNameTemplate, AnnotationDescriptionTemplate, AnnotationTopicTemplate, AnnotationMessageTemplate} {
+		if v, ok := annotations[key]; ok {
+			if _, err := parseAnnotationTemplate(key, v); err != nil {
+				errs[key] = err
+			}
+		}
+	}
+	if v, ok := annotations[AnnotationChecks]; ok {
+		if _, err := parseCheckSelection(v); err != nil {
+			errs[AnnotationChecks] = err
+		}
+	}
+	if v, ok := annotations[AnnotationHysteresis]; ok {
+		if _, err := parseObservations(v); err != nil {
+			errs[AnnotationHysteresis] = err
+		}
+	}
+	for k, v := range annotations {
+		if checkType, ok := strings.CutPrefix(k, AnnotationThresholdPrefix); ok {
+			if _, _, err := parseThreshold(checkType, v); err != nil {
+				errs[k] = err
+			}
+		}
+		if checkType, ok := strings.CutPrefix(k, AnnotationExitThresholdPrefix); ok {
+			if _, err := parseExitThreshold(checkType, v); err != nil {
+				errs[k] = err
+			}
+		}
+	}
+	return errs
+}
+
+// thresholdSpec describes how a tinymon.io/threshold.<type> annotation is
+// interpreted and holds the defaults.
+type thresholdSpec struct {
+	warn, crit float64
+	// lowerIsWorse is set for values like the share of ready replicas,
+	// where the status gets worse as the value drops.
+	lowerIsWorse bool
+	// unit is the optional suffix of the values, "%" for percentages.
+	unit string
+}
+
+// thresholdSpecs are the check types that support thresholds.
+var thresholdSpecs = map[string]thresholdSpec{
+	"memory":     {warn: 80, crit: 90, unit: "%"},
+	"load":       {warn: 80, crit: 90, unit: "%"},
+	"disk":       {warn: 80, crit: 90, unit: "%"},
+	"replicas":   {warn: 100, crit: 0, lowerIsWorse: true, unit: "%"},
+	"backup-age": {warn: 48, crit: math.Inf(1), unit: "h"},
+}
+
+// thresholds returns the warning and critical thresholds for a check type,
+// read from a "tinymon.io/threshold.<type>" annotation in the form
+// "warning,critical" (e.g. "85,95") or "warning" (e.g. "50%"), which keeps
+// the default critical threshold. Invalid values fall back to the defaults.
+func thresholds(annotations map[string]string, checkType string) (float64, float64) {
+	spec := thresholdSpecs[checkType]
+	v, ok := annotations[AnnotationThresholdPrefix+checkType]
+	if !ok {
+		return spec.warn, spec.crit
+	}
+	warn, crit, err := parseThreshold(checkType, v)
+	if err != nil {
+		return spec.warn, spec.crit
+	}
+	return warn, crit
+}
+
+// parseThreshold parses the value of the threshold annotation of checkType.
+func parseThreshold(checkType, v string) (float64, float64, error) {
+	spec, ok := thresholdSpecs[checkType]
+	if !ok {
+		types := make([]string, 0, len(thresholdSpecs))
+		for t := range thresholdSpecs {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return 0, 0, fmt.Errorf("no thresholds for check type %q, supported are %s", checkType, strings.Join(types, ", "))
+	}
+
+	parts := strings.Split(v, ",")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("%q is not in the form \"warning,critical\" or \"warning\"", v)
+	}
+	values := make([]float64, len(parts))
+	for i, part := range parts {
+		part = strings.TrimSuffix(strings.TrimSpace(part), spec.unit)
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%q is not a number%s", parts[i], unitHint(spec.unit))
+		}
+		if spec.unit == "%" && (f < 0 || f > 100) {
+			return 0, 0, fmt.Errorf("%q is not a percentage between 0 and 100", parts[i])
+		}
+		values[i] = f
+	}
+
+	warn, crit := values[0], spec.crit
+	if len(values) == 2 {
+		crit = values[1]
+	}
+	if spec.lowerIsWorse && warn < crit {
+		return 0, 0, fmt.Errorf("warning %v must not be below critical %v, lower values are worse for %s", warn, crit, checkType)
+	}
+	if !spec.lowerIsWorse && warn > crit {
+		return 0, 0, fmt.Errorf("warning %v must not be above critical %v", warn, crit)
+	}
+	return warn, crit, nil
+}
+
+func unitHint(unit string) string {
+	if unit == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (optionally with %q)", unit)
+}
+
+// thresholdStatus returns the status of value for checkType using the
+// thresholds from annotations.
+func thresholdStatus(annotations map[string]string, checkType string, value float64) string {
+	warn, crit := thresholds(annotations, checkType)
+	if thresholdSpecs[checkType].lowerIsWorse {
+		switch {
+		case value <= crit:
+			return "critical"
+		case value < warn:
+			return "warning"
+		}
+		return "ok"
+	}
+	switch {
+	case value >= crit:
+		return "critical"
+	case value >= warn:
+		return "warning"
+	}
+	return "ok"
+}
+
 // extractLabels extracts Kubernetes labels with prefix "tinymon.io/label-"
 // and returns a clean map with the prefix stripped.
 func extractLabels(labels map[string]string) map[string]string {
@@ -96,3 +339,32 @@ func buildLabels(cluster string, resourceType string, k8sLabels map[string]strin
 	}
 	return result
 }
+
+// listRequests lists all objects of the given list type and returns a
+// reconcile request for each of them.
+func listRequests(ctx context.Context, c client.Reader, listType client.ObjectList, opts ...client.ListOption) []reconcile.Request {
+	list := listType.DeepCopyObject().(client.ObjectList)
+	if err := c.List(ctx, list, opts...); err != nil {
+		return nil
+	}
+	return listItemRequests(list)
+}
+
+// listItemRequests returns a request for every object in list.
+func listItemRequests(list client.ObjectList) []reconcile.Request {
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(items))
+	for _, item := range items {
+		obj, ok := item.(client.Object)
+		if !ok {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()},
+		})
+	}
+	return requests
+}
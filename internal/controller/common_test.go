@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"math"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		checkType string
+		value     string
+		warn      float64
+		crit      float64
+		wantErr   bool
+	}{
+		{name: "warning and critical", checkType: "memory", value: "85,95", warn: 85, crit: 95},
+		{name: "warning only keeps critical", checkType: "memory", value: "85", warn: 85, crit: 90},
+		{name: "percent sign", checkType: "disk", value: "70%, 80%", warn: 70, crit: 80},
+		{name: "unit suffix", checkType: "backup-age", value: "26h,50h", warn: 26, crit: 50},
+		{name: "no critical by default", checkType: "backup-age", value: "26", warn: 26, crit: math.Inf(1)},
+		{name: "warning above critical", checkType: "memory", value: "95,85", wantErr: true},
+		{name: "equal thresholds", checkType: "memory", value: "90,90", warn: 90, crit: 90},
+		{name: "percentage above 100", checkType: "load", value: "120", wantErr: true},
+		{name: "negative percentage", checkType: "load", value: "-5", wantErr: true},
+		{name: "not a number", checkType: "memory", value: "high", wantErr: true},
+		{name: "too many values", checkType: "memory", value: "70,80,90", wantErr: true},
+		{name: "unknown type", checkType: "latency", value: "100", wantErr: true},
+		{name: "lower is worse", checkType: "replicas", value: "50%,25%", warn: 50, crit: 25},
+		{name: "lower is worse keeps critical", checkType: "replicas", value: "50%", warn: 50, crit: 0},
+		{name: "lower is worse, warning below critical", checkType: "replicas", value: "25,50", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warn, crit, err := parseThreshold(tt.checkType, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseThreshold(%q, %q) error = %v, want error %v", tt.checkType, tt.value, err, tt.wantErr)
+			}
+			if err == nil && (warn != tt.warn || crit != tt.crit) {
+				t.Errorf("parseThreshold(%q, %q) = %v, %v, want %v, %v", tt.checkType, tt.value, warn, crit, tt.warn, tt.crit)
+			}
+		})
+	}
+}
+
+func TestThresholdStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		checkType string
+		threshold string
+		value     float64
+		want      string
+	}{
+		{name: "below warning", checkType: "memory", value: 79, want: "ok"},
+		{name: "at warning", checkType: "memory", value: 80, want: "warning"},
+		{name: "at critical", checkType: "memory", value: 90, want: "critical"},
+		{name: "annotation", checkType: "memory", threshold: "85,95", value: 90, want: "warning"},
+		{name: "invalid annotation uses defaults", checkType: "memory", threshold: "95,85", value: 90, want: "critical"},
+		{name: "lower is worse, all there", checkType: "replicas", value: 100, want: "ok"},
+		{name: "lower is worse, some missing", checkType: "replicas", value: 50, want: "warning"},
+		{name: "lower is worse, none", checkType: "replicas", value: 0, want: "critical"},
+		{name: "lower is worse, above warning", checkType: "replicas", threshold: "50%", value: 60, want: "ok"},
+		{name: "lower is worse, at critical", checkType: "replicas", threshold: "50,25", value: 25, want: "critical"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var annotations map[string]string
+			if tt.threshold != "" {
+				annotations = map[string]string{AnnotationThresholdPrefix + tt.checkType: tt.threshold}
+			}
+			if got := thresholdStatus(annotations, tt.checkType, tt.value); got != tt.want {
+				t.Errorf("thresholdStatus(%v, %q, %v) = %q, want %q", annotations, tt.checkType, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeploymentStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		threshold   string
+		exit        string // configured exit threshold
+		desired     int32
+		ready       int32
+		available   int32
+		want        string
+		wantMessage string
+	}{
+		{name: "all ready", desired: 3, ready: 3, available: 3, want: "ok", wantMessage: "3/3 replicas ready"},
+		{name: "some ready", desired: 3, ready: 2, available: 2, want: "warning", wantMessage: "2/3 replicas ready"},
+		{name: "none ready", desired: 3, want: "critical", wantMessage: "0/3 replicas ready"},
+		{name: "ready but not available", desired: 3, ready: 3, want: "warning", wantMessage: "3/3 replicas ready"},
+		{name: "surge", desired: 3, ready: 4, available: 4, want: "warning", wantMessage: "4/3 replicas ready"},
+		{name: "scaled to zero", desired: 0, want: "ok", wantMessage: "0/0 replicas ready"},
+		{name: "threshold", threshold: "50%", desired: 4, ready: 3, available: 2, want: "ok", wantMessage: "2/4 replicas ready and available"},
+		{name: "threshold, below warning", threshold: "50%", desired: 4, ready: 3, available: 1, want: "warning", wantMessage: "1/4 replicas ready and available"},
+		{name: "configured exit threshold", exit: "75", desired: 4, ready: 3, available: 3, want: "warning", wantMessage: "3/4 replicas ready and available"},
+		{name: "configured exit threshold, surge", exit: "75", desired: 3, ready: 4, available: 4, want: "ok", wantMessage: "4/3 replicas ready and available"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := &appsv1.Deployment{}
+			deploy.Spec.Replicas = &tt.desired
+			deploy.Status.ReadyReplicas = tt.ready
+			deploy.Status.AvailableReplicas = tt.available
+			var annotations map[string]string
+			if tt.threshold != "" {
+				annotations = map[string]string{AnnotationThresholdPrefix + "replicas": tt.threshold}
+			}
+			var h *Hysteresis
+			if tt.exit != "" {
+				var err error
+				if h, err = NewHysteresis(HysteresisConfig{ExitThresholds: map[string]string{"replicas": tt.exit}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			status, msg := deploymentStatus(deploy, annotations, h, "k8s://prod/deployment/shop/web")
+			if status != tt.want || msg != tt.wantMessage {
+				t.Errorf("deploymentStatus() = %q, %q, want %q, %q", status, msg, tt.want, tt.wantMessage)
+			}
+		})
+	}
+}
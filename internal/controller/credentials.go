@@ -0,0 +1,344 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationCredentialsSecret on a Namespace names a Secret in the same
+// Namespace with the TinyMon URL and API key that the hosts, checks and
+// results of all objects in the Namespace are sent to.
+const AnnotationCredentialsSecret = "tinymon.io/credentials-secret"
+
+// Keys of a credentials Secret, the same as in the operator's own Secret.
+const (
+	SecretKeyURL    = "tinymon-url"
+	SecretKeyAPIKey = "tinymon-api-key"
+)
+
+// secretTTL is how long a credentials Secret is used before it is read
+// again, so rotated keys are picked up without watching all Secrets.
+const secretTTL = time.Minute
+
+// credentialKey identifies a TinyMon instance and API key.
+type credentialKey struct {
+	url, apiKey string
+}
+
+// cachedSecret is the credentials read from a Secret.
+type cachedSecret struct {
+	key     credentialKey
+	fetched time.Time
+}
+
+// HostLister lists the hosts in TinyMon.
+type HostLister interface {
+	ListHosts() ([]tinymon.Host, error)
+}
+
+// Credentials keeps a pool of TinyMon clients, one per URL and API key, for
+// Namespaces with their own credentials. A nil Credentials sends everything
+// to the operator's TinyMon.
+type Credentials struct {
+	// Default is the operator's own client.
+	Default *tinymon.Client
+	// Reader reads the Secrets. It should be uncached, so the operator
+	// doesn't watch every Secret in the cluster.
+	Reader client.Reader
+	// Wrap is applied to every pooled client, e.g. to record calls for the
+	// debug endpoint.
+	Wrap func(tinymon.API) tinymon.API
+
+	mu      sync.Mutex
+	secrets map[types.NamespacedName]cachedSecret
+	clients map[credentialKey]*tinymon.Client
+	routes  map[string]types.NamespacedName // namespace -> Secret
+	// used is the TinyMon each Namespace was sent to last, the zero key for
+	// the default one. moved holds the ones a Namespace was sent to before,
+	// whose hosts are still to be removed, with the last attempt.
+	used  map[string]credentialKey
+	moved map[string]map[credentialKey]time.Time
+}
+
+func NewCredentials(def *tinymon.Client, reader client.Reader, wrap func(tinymon.API) tinymon.API) *Credentials {
+	return &Credentials{
+		Default: def,
+		Reader:  reader,
+		Wrap:    wrap,
+		secrets: make(map[types.NamespacedName]cachedSecret),
+		clients: make(map[credentialKey]*tinymon.Client),
+		routes:  make(map[string]types.NamespacedName),
+		used:    make(map[string]credentialKey),
+		moved:   make(map[string]map[credentialKey]time.Time),
+	}
+}
+
+// tinymon returns the TinyMon API for objects in namespace: a client for
+// the Secret named by the Namespace's tinymon.io/credentials-secret
+// annotation, else tm. Errors are returned instead of falling back to tm,
+// which would send a team's hosts to the wrong TinyMon.
+func (o Options) tinymon(ctx context.Context, c client.Reader, tm tinymon.API, namespace string) (tinymon.API, error) {
+	if o.Credentials == nil || namespace == "" {
+		return tm, nil
+	}
+	api, err := o.Credentials.api(ctx, c, tm, namespace)
+	if err != nil {
+		return nil, err
+	}
+	o.removeMovedHosts(ctx, tm, namespace)
+	return api, nil
+}
+
+func (cr *Credentials) api(ctx context.Context, c client.Reader, tm tinymon.API, namespace string) (tinymon.API, error) {
+	var ns corev1.Namespace
+	var secret types.NamespacedName
+	err := c.Get(ctx, types.NamespacedName{Name: namespace}, &ns)
+	switch {
+	case err == nil:
+		name := ns.Annotations[AnnotationCredentialsSecret]
+		cr.mu.Lock()
+		if name == "" {
+			delete(cr.routes, namespace)
+		} else {
+			secret = types.NamespacedName{Namespace: namespace, Name: name}
+			cr.routes[namespace] = secret
+		}
+		cr.mu.Unlock()
+	case errors.IsNotFound(err):
+		// The Namespace is being deleted together with its objects, whose
+		// hosts are removed from the TinyMon they were synced to.
+		cr.mu.Lock()
+		secret = cr.routes[namespace]
+		cr.mu.Unlock()
+	default:
+		return nil, err
+	}
+	if secret.Name == "" {
+		cr.route(namespace, credentialKey{})
+		return tm, nil
+	}
+
+	cl, key, err := cr.client(ctx, secret, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	cr.route(namespace, key)
+	if cr.Wrap != nil {
+		return cr.Wrap(cl), nil
+	}
+	return cl, nil
+}
+
+// client returns the pooled client for the credentials in secret. While
+// the Secret can't be read, e.g. because it was deleted with its Namespace,
+// the credentials read last are used.
+func (cr *Credentials) client(ctx context.Context, secret types.NamespacedName, now time.Time) (*tinymon.Client, credentialKey, error) {
+	cr.mu.Lock()
+	cached, ok := cr.secrets[secret]
+	cr.mu.Unlock()
+	if !ok || now.Sub(cached.fetched) >= secretTTL {
+		key, err := cr.read(ctx, secret)
+		switch {
+		case err == nil:
+			cached = cachedSecret{key: key, fetched: now}
+			cr.mu.Lock()
+			cr.secrets[secret] = cached
+			cr.mu.Unlock()
+		case ok:
+			ctrl.LoggerFrom(ctx).Info("failed to read credentials Secret, using the last credentials", "secret", secret, "error", err.Error())
+		default:
+			return nil, credentialKey{}, err
+		}
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cl, ok := cr.clients[cached.key]
+	if !ok {
+		cl = tinymon.NewClient(cached.key.url, cached.key.apiKey)
+		cr.clients[cached.key] = cl
+	}
+	cr.prune()
+	return cl, cached.key, nil
+}
+
+// route records that the objects in namespace are sent to the TinyMon of
+// key. If it has another URL than the one used before, the Namespace moved
+// to another TinyMon and the previous one is remembered until its hosts are
+// removed. A new API key for the same URL is a rotation, not a move.
+func (cr *Credentials) route(namespace string, key credentialKey) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	previous, ok := cr.used[namespace]
+	cr.used[namespace] = key
+	if !ok || previous.url == key.url {
+		return
+	}
+	moved := cr.moved[namespace]
+	if moved == nil {
+		moved = make(map[credentialKey]time.Time)
+		cr.moved[namespace] = moved
+	}
+	// Moving back to a TinyMon keeps its hosts.
+	for k := range moved {
+		if k.url == key.url {
+			delete(moved, k)
+		}
+	}
+	if _, ok := moved[previous]; !ok {
+		moved[previous] = time.Time{}
+	}
+}
+
+// movedFrom returns the TinyMons namespace moved away from whose hosts are
+// due to be removed, at most once per loadInterval each.
+func (cr *Credentials) movedFrom(namespace string, now time.Time) []credentialKey {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	var keys []credentialKey
+	for key, attempt := range cr.moved[namespace] {
+		if now.Sub(attempt) >= loadInterval {
+			cr.moved[namespace][key] = now
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// movedHostsRemoved records that the hosts of namespace in the TinyMon of
+// key were removed.
+func (cr *Credentials) movedHostsRemoved(namespace string, key credentialKey) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	delete(cr.moved[namespace], key)
+	if len(cr.moved[namespace]) == 0 {
+		delete(cr.moved, namespace)
+	}
+}
+
+// removeMovedHosts applies the deletion policy to the hosts of the objects
+// in namespace in the TinyMons it moved away from, tm being the default one.
+// Hosts that are already disabled are left to the host reaper.
+func (o Options) removeMovedHosts(ctx context.Context, tm tinymon.API, namespace string) {
+	cr := o.Credentials
+	for _, key := range cr.movedFrom(namespace, time.Now()) {
+		api, lister := tm, HostLister(cr.Default)
+		if key != (credentialKey{}) {
+			cl := tinymon.NewClient(key.url, key.apiKey)
+			api, lister = cl, cl
+			if cr.Wrap != nil {
+				api = cr.Wrap(cl)
+			}
+		}
+		if err := o.removeNamespaceHosts(ctx, api, lister, namespace); err != nil {
+			ctrl.LoggerFrom(ctx).Error(err, "failed to remove the hosts of the Namespace from its previous TinyMon, retrying", "namespace", namespace)
+			continue
+		}
+		cr.movedHostsRemoved(namespace, key)
+	}
+}
+
+// removeNamespaceHosts applies the global deletion policy to the hosts of
+// the objects of this cluster in namespace. Their results are pushed to the
+// new TinyMon right away instead of after the heartbeat.
+func (o Options) removeNamespaceHosts(ctx context.Context, api tinymon.API, lister HostLister, namespace string) error {
+	hosts, err := lister.ListHosts()
+	if err != nil {
+		return err
+	}
+	policy := o.deletionPolicy(nil)
+	prefix := "k8s://" + o.Cluster + "/"
+	now := time.Now()
+	for _, h := range hosts {
+		rest, ok := strings.CutPrefix(h.Address, prefix)
+		parts := strings.SplitN(rest, "/", 3)
+		if !ok || len(parts) != 3 || parts[1] != namespace {
+			continue
+		}
+		o.Heartbeat.forget(h.Address)
+		if _, disabled := disabledSince(h); disabled || policy == DeletionPolicyRetain {
+			continue
+		}
+		ctrl.LoggerFrom(ctx).Info("removing host from the Namespace's previous TinyMon", "address", h.Address, "deletionPolicy", policy)
+		if policy == DeletionPolicyDisable {
+			err = api.UpsertHost(disabledHost(h, now))
+		} else {
+			err = api.DeleteHost(h.Address)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read reads the credentials from secret.
+func (cr *Credentials) read(ctx context.Context, secret types.NamespacedName) (credentialKey, error) {
+	var s corev1.Secret
+	if err := cr.Reader.Get(ctx, secret, &s); err != nil {
+		return credentialKey{}, fmt.Errorf("credentials Secret %s: %w", secret, err)
+	}
+	key := credentialKey{url: string(s.Data[SecretKeyURL]), apiKey: string(s.Data[SecretKeyAPIKey])}
+	if key.url == "" || key.apiKey == "" {
+		return credentialKey{}, fmt.Errorf("credentials Secret %s must contain %s and %s", secret, SecretKeyURL, SecretKeyAPIKey)
+	}
+	return key, nil
+}
+
+// prune drops the clients of rotated keys. cr.mu must be held.
+func (cr *Credentials) prune() {
+	if len(cr.clients) <= len(cr.secrets) {
+		return
+	}
+	used := make(map[credentialKey]bool, len(cr.secrets))
+	for _, s := range cr.secrets {
+		used[s.key] = true
+	}
+	for key := range cr.clients {
+		if !used[key] {
+			delete(cr.clients, key)
+		}
+	}
+}
+
+// Clients returns the default client followed by the pooled ones.
+func (cr *Credentials) Clients() []*tinymon.Client {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	clients := []*tinymon.Client{cr.Default}
+	for _, cl := range cr.clients {
+		clients = append(clients, cl)
+	}
+	return clients
+}
+
+// ListHosts lists the hosts of all clients, so the disable deletion policy
+// knows the hosts in every TinyMon. Pooled clients that fail are skipped, so
+// a single team's TinyMon doesn't hold up the others.
+func (cr *Credentials) ListHosts() ([]tinymon.Host, error) {
+	clients := cr.Clients()
+	hosts, err := clients[0].ListHosts()
+	if err != nil {
+		return nil, err
+	}
+	for _, cl := range clients[1:] {
+		h, err := cl.ListHosts()
+		if err != nil {
+			ctrl.Log.WithName("credentials").Error(err, "failed to list hosts of a Namespace's TinyMon")
+			continue
+		}
+		hosts = append(hosts, h...)
+	}
+	return hosts, nil
+}
@@ -0,0 +1,305 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/shard"
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// AnnotationDeletionPolicy overrides the global deletion policy for an
+// object.
+const AnnotationDeletionPolicy = "tinymon.io/deletion-policy"
+
+// LabelDisabledSince is the host label holding the time a host was disabled
+// by the disable deletion policy, so the retention survives restarts.
+const LabelDisabledSince = "disabled-since"
+
+// DeletionPolicy decides what happens to the host of an object when
+// monitoring is turned off or the object is deleted.
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the host and its history.
+	DeletionPolicyDelete DeletionPolicy = "delete"
+	// DeletionPolicyDisable keeps the host and its history but disables it.
+	// Disabled hosts are deleted after the retention period, if one is set.
+	DeletionPolicyDisable DeletionPolicy = "disable"
+	// DeletionPolicyRetain leaves the host untouched, it is no longer
+	// updated.
+	DeletionPolicyRetain DeletionPolicy = "retain"
+)
+
+// ParseDeletionPolicy parses delete, disable or retain.
+func ParseDeletionPolicy(s string) (DeletionPolicy, error) {
+	switch p := DeletionPolicy(s); p {
+	case DeletionPolicyDelete, DeletionPolicyDisable, DeletionPolicyRetain:
+		return p, nil
+	}
+	return "", fmt.Errorf("%q is not a deletion policy, expected delete, disable or retain", s)
+}
+
+// deletionPolicy returns the policy for an object with the given
+// annotations, which are nil for deleted objects. Invalid annotations are ignored,
+// they are reported by validateAnnotations.
+func (o Options) deletionPolicy(annotations map[string]string) DeletionPolicy {
+	if p, err := ParseDeletionPolicy(annotations[AnnotationDeletionPolicy]); err == nil {
+		return p
+	}
+	if o.DeletionPolicy != "" {
+		return o.DeletionPolicy
+	}
+	return DeletionPolicyDelete
+}
+
+// hostKeeper is implemented by tinymon.DryRun, which is told the hosts the
+// deletion policy keeps, so gc and diff leave them alone.
+type hostKeeper interface {
+	KeepHost(address string)
+}
+
+// removeHost applies policy to host, the host as it would be synced.
+func (o Options) removeHost(tm tinymon.API, policy DeletionPolicy, host tinymon.Host) error {
+	if k, ok := tm.(hostKeeper); ok && policy != DeletionPolicyDelete {
+		k.KeepHost(host.Address)
+	}
+	switch policy {
+	case DeletionPolicyRetain:
+		return nil
+	case DeletionPolicyDisable:
+		since, ok := o.Disabled.disable(host.Address, time.Now())
+		if !ok {
+			return nil
+		}
+		if err := tm.UpsertHost(disabledHost(host, since)); err != nil {
+			o.Disabled.failed(host.Address)
+			return err
+		}
+		return nil
+	default:
+		o.Disabled.deleted(host.Address)
+		return tm.DeleteHost(host.Address)
+	}
+}
+
+// disabledHost returns host disabled since the given time.
+func disabledHost(host tinymon.Host, since time.Time) tinymon.Host {
+	labels := make(map[string]string, len(host.Labels)+1)
+	for k, v := range host.Labels {
+		labels[k] = v
+	}
+	labels[LabelDisabledSince] = since.UTC().Format(time.RFC3339)
+	host.Labels = labels
+	host.Enabled = 0
+	return host
+}
+
+// deletedHost is the host of a deleted object. Its annotations are gone, so
+// only the defaults are known.
+func (o Options) deletedHost(addr, name, description, topicKind, namespace, hostType string) tinymon.Host {
+	return tinymon.Host{
+		Name:        name,
+		Address:     addr,
+		Description: description + " (deleted)",
+		Topic:       defaultTopic(o.Cluster, topicKind, namespace, nil),
+		Labels:      buildLabels(o.Cluster, hostType, nil),
+	}
+}
+
+// DisabledHosts remembers which hosts exist in TinyMon and which of them are
+// disabled, so a disabled object is disabled in TinyMon once instead of on
+// every reconcile, its retention isn't restarted, and objects that were never
+// monitored don't get a disabled host. A nil DisabledHosts disables nothing.
+type DisabledHosts struct {
+	lister HostLister
+
+	mu       sync.Mutex
+	loaded   bool
+	lastLoad time.Time
+	disabled map[string]time.Time // address -> disabled since
+	existing map[string]bool
+}
+
+// loadInterval limits how often the hosts are listed while TinyMon fails or
+// hosts are unknown.
+const loadInterval = time.Minute
+
+func NewDisabledHosts(lister HostLister) *DisabledHosts {
+	return &DisabledHosts{
+		lister:   lister,
+		disabled: make(map[string]time.Time),
+		existing: make(map[string]bool),
+	}
+}
+
+// disable records the host at addr as disabled and returns since when. It
+// returns false if the host is already disabled, or isn't known to exist,
+// e.g. because the object was never monitored or the host was deleted after
+// the retention, so there is nothing to keep.
+func (d *DisabledHosts) disable(addr string, now time.Time) (time.Time, bool) {
+	if d == nil || !d.load(addr, now) {
+		return time.Time{}, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.disabled[addr]; ok || !d.existing[addr] {
+		return time.Time{}, false
+	}
+	d.disabled[addr] = now
+	return now, true
+}
+
+// load reads the hosts and their disabled-since labels from TinyMon, and
+// reports whether they are loaded. They are read again if addr is unknown,
+// e.g. because it is in the TinyMon of a Namespace that wasn't used yet.
+func (d *DisabledHosts) load(addr string, now time.Time) bool {
+	if d.lister == nil {
+		return false
+	}
+	d.mu.Lock()
+	if (d.loaded && d.existing[addr]) || now.Sub(d.lastLoad) < loadInterval {
+		defer d.mu.Unlock()
+		return d.loaded
+	}
+	d.lastLoad = now
+	d.mu.Unlock()
+
+	hosts, err := d.lister.ListHosts()
+	if err != nil {
+		ctrl.Log.WithName("deletion").Error(err, "failed to load hosts, not disabling hosts until TinyMon can be listed")
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, h := range hosts {
+		d.existing[h.Address] = true
+		if t, ok := disabledSince(h); ok {
+			d.disabled[h.Address] = t
+		}
+	}
+	d.loaded = true
+	return true
+}
+
+// synced records that the host at addr was synced enabled.
+func (d *DisabledHosts) synced(addr string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.disabled, addr)
+	d.existing[addr] = true
+}
+
+// failed forgets that the host at addr was disabled, disabling it failed.
+func (d *DisabledHosts) failed(addr string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.disabled, addr)
+}
+
+// deleted records that the host at addr was deleted.
+func (d *DisabledHosts) deleted(addr string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.disabled, addr)
+	delete(d.existing, addr)
+}
+
+// forget drops what is known about the host at addr, which is synced by
+// another replica now.
+func (d *DisabledHosts) forget(addr string) {
+	d.deleted(addr)
+}
+
+// disabledSince returns the time h was disabled by the disable policy.
+func disabledSince(h tinymon.Host) (time.Time, bool) {
+	if h.Enabled != 0 || h.Labels[LabelDisabledSince] == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, h.Labels[LabelDisabledSince])
+	return t, err == nil
+}
+
+// HostReaper deletes the hosts of this cluster that have been disabled by
+// the disable deletion policy for longer than Retention, in the operator's
+// TinyMon and in the ones of Namespaces with their own credentials. With
+// Shards, only one replica deletes them. It implements manager.Runnable.
+type HostReaper struct {
+	Client      *tinymon.Client
+	Credentials *Credentials
+	Shards      *shard.Ring
+	Cluster     string
+	Retention   time.Duration
+	Interval    time.Duration
+	Disabled    *DisabledHosts
+}
+
+func (r *HostReaper) Start(ctx context.Context) error {
+	log := ctrl.Log.WithName("host-reaper")
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.reap(ctrl.LoggerInto(ctx, log)); err != nil {
+			log.Error(err, "failed to delete expired hosts")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *HostReaper) reap(ctx context.Context) error {
+	if owned, _ := r.Shards.Owns("host-reaper/" + r.Cluster); !owned {
+		return nil
+	}
+	clients := []*tinymon.Client{r.Client}
+	if r.Credentials != nil {
+		clients = r.Credentials.Clients()
+	}
+	var lastErr error
+	for _, cl := range clients {
+		if err := r.reapClient(ctx, cl); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (r *HostReaper) reapClient(ctx context.Context, cl *tinymon.Client) error {
+	hosts, err := cl.ListHosts()
+	if err != nil {
+		return err
+	}
+	log := ctrl.LoggerFrom(ctx)
+	now := time.Now()
+	var lastErr error
+	for _, h := range hosts {
+		since, ok := disabledSince(h)
+		if !ok || h.Labels["cluster"] != r.Cluster || now.Sub(since) < r.Retention {
+			continue
+		}
+		log.Info("deleting host disabled longer than the retention", "address", h.Address, "disabledSince", since)
+		if err := cl.DeleteHost(h.Address); err != nil {
+			lastErr = err
+			continue
+		}
+		r.Disabled.deleted(h.Address)
+	}
+	return lastErr
+}
@@ -2,108 +2,200 @@ package controller
 
 import (
 	"context"
-	"time"
 	"fmt"
+	"time"
 
+	tinymonv1alpha1 "github.com/unclesamwk/tinymon-operator/api/v1alpha1"
 	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
 
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 type DeploymentReconciler struct {
 	client.Client
-	TinyMon *tinymon.Client
-	Cluster string
+	// APIReader reads objects watched metadata-only in full.
+	APIReader client.Reader
+	TinyMon   tinymon.API
+	Options
 }
 
-func SetupDeploymentReconciler(mgr ctrl.Manager, tm *tinymon.Client, cluster string) error {
+func SetupDeploymentReconciler(mgr ctrl.Manager, tm tinymon.API, opts Options) error {
+	list := opts.listType(mgr.GetScheme(), &appsv1.DeploymentList{})
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&appsv1.Deployment{}).
-		Complete(&DeploymentReconciler{Client: mgr.GetClient(), TinyMon: tm, Cluster: cluster})
+		For(&appsv1.Deployment{}, opts.forOptions(&appsv1.Deployment{})...).
+		Watches(&corev1.Namespace{}, namespaceHandler(mgr.GetClient(), list), builder.WithPredicates(namespaceDefaultsChanged)).
+		Watches(&tinymonv1alpha1.MonitoringPolicy{}, policyHandler(mgr.GetClient(), list), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WatchesRawSource(opts.rebalanceSource(mgr.GetClient(), list)).
+		Complete(opts.reconciler("deployment", &DeploymentReconciler{Client: mgr.GetClient(), APIReader: mgr.GetAPIReader(), TinyMon: tm, Options: opts}))
 }
 
 func (r *DeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx).WithValues("deployment", req.NamespacedName)
 
 	var deploy appsv1.Deployment
-	if err := r.Get(ctx, req.NamespacedName, &deploy); err != nil {
+	if err := r.getObject(ctx, r.Client, r.APIReader, KindDeployment, req.NamespacedName, &deploy); err != nil {
 		if errors.IsNotFound(err) {
-			log.Info("deployment deleted, removing from TinyMon")
+			policy := r.deletionPolicy(nil)
+			log.Info("deployment deleted, removing from TinyMon", "deletionPolicy", policy)
 			addr := resourceAddress(r.Cluster, "deployment", req.Namespace, req.Name)
-			_ = r.TinyMon.DeleteHost(addr)
+			api, err := r.tinymon(ctx, r.Client, r.TinyMon, req.Namespace)
+			if err != nil {
+				log.Error(err, "failed to resolve TinyMon credentials")
+				return ctrl.Result{}, err
+			}
+			host := r.deletedHost(addr, req.Name, fmt.Sprintf("Deployment %s/%s", req.Namespace, req.Name), "deployments", req.Namespace, "app")
+			if err := r.removeHost(api, policy, host); err != nil {
+				log.Error(err, "failed to remove host")
+				return ctrl.Result{}, err
+			}
+			r.reportRemoved(ctx, r.Client, nil, addr, policy)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
 
-	if !isEnabled(deploy.Annotations) {
-		addr := resourceAddress(r.Cluster, "deployment", deploy.Namespace, deploy.Name)
-		_ = r.TinyMon.DeleteHost(addr)
-		return ctrl.Result{}, nil
+	annotations, labels, err := effectiveMetadata(ctx, r.Client, KindDeployment, &deploy)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
 	addr := resourceAddress(r.Cluster, "deployment", deploy.Namespace, deploy.Name)
-	interval := checkInterval(deploy.Annotations, 60)
-	t := defaultTopic(r.Cluster, "deployments", deploy.Namespace, deploy.Annotations)
+	api, err := r.tinymon(ctx, r.Client, r.TinyMon, deploy.Namespace)
+	if err != nil {
+		log.Error(err, "failed to resolve TinyMon credentials")
+		r.reportFailed(ctx, r.Client, KindDeployment, &deploy, addr, err)
+		return ctrl.Result{}, err
+	}
+	interval := checkInterval(annotations, 60)
 
+	data := r.templateData(ctx, r.Client, KindDeployment, &deploy, annotations, deploymentValues(&deploy))
 	host := tinymon.Host{
-		Name:        displayName(deploy.Annotations, deploy.Name),
+		Name:        r.hostName(ctx, annotations, data),
 		Address:     addr,
-		Description: fmt.Sprintf("Deployment %s/%s", deploy.Namespace, deploy.Name),
-		Topic:       t,
-		Labels:      buildLabels(r.Cluster, "app", deploy.Labels),
+		Description: r.hostDescription(ctx, annotations, data, fmt.Sprintf("Deployment %s/%s", deploy.Namespace, deploy.Name)),
+		Topic:       r.hostTopic(ctx, annotations, data, defaultTopic(r.Cluster, "deployments", deploy.Namespace, annotations)),
+		Labels:      r.hostLabels("app", &deploy, data, labels),
 		Enabled:     1,
 	}
 
+	if !isEnabled(annotations) {
+		policy := r.deletionPolicy(annotations)
+		if err := r.removeHost(api, policy, host); err != nil {
+			log.Error(err, "failed to remove host")
+			return ctrl.Result{}, err
+		}
+		r.reportRemoved(ctx, r.Client, &deploy, addr, policy)
+		return ctrl.Result{}, nil
+	}
+
+	r.reportAnnotations(KindDeployment, &deploy, annotations)
+
+	now := time.Now()
+	maint := maintenanceState(annotations, now)
+	tm := maint.api(api)
+	if maint.active() {
+		log.Info("in maintenance, checks disabled", "until", maint.until)
+	}
+
 	log.Info("syncing deployment to TinyMon", "address", addr)
-	if err := r.TinyMon.UpsertHost(host); err != nil {
+	if err := tm.UpsertHost(host); err != nil {
 		log.Error(err, "failed to upsert host")
+		r.reportFailed(ctx, r.Client, KindDeployment, &deploy, addr, err)
 		return ctrl.Result{}, err
 	}
 
-	check := tinymon.Check{
-		HostAddress:     addr,
-		Type:            "status",
-		IntervalSeconds: interval,
-		Enabled:         1,
-	}
-	if err := r.TinyMon.UpsertCheck(check); err != nil {
-		log.Error(err, "failed to upsert check")
+	checks := selectedChecks(annotations)
+	if err := r.removeDeselectedChecks(tm, addr, []string{"status"}, checks); err != nil {
+		log.Error(err, "failed to delete deselected checks")
+		r.reportFailed(ctx, r.Client, KindDeployment, &deploy, addr, err)
 		return ctrl.Result{}, err
 	}
 
-	status, msg := deploymentStatus(&deploy)
-	results := []tinymon.Result{{
-		HostAddress: addr,
-		CheckType:   "status",
-		Status:      status,
-		Message:     msg,
-	}}
-	if err := r.TinyMon.PushBulk(results); err != nil {
-		log.Error(err, "failed to push bulk results")
-		return ctrl.Result{}, err
+	var results []tinymon.Result
+	if checks.enabled("status") {
+		check := tinymon.Check{
+			HostAddress:     addr,
+			Type:            "status",
+			IntervalSeconds: r.Heartbeat.interval(interval),
+			Enabled:         1,
+		}
+		if err := tm.UpsertCheck(check); err != nil {
+			log.Error(err, "failed to upsert check")
+			r.reportFailed(ctx, r.Client, KindDeployment, &deploy, addr, err)
+			return ctrl.Result{}, err
+		}
+
+		status, msg := deploymentStatus(&deploy, annotations, r.Hysteresis, addr)
+		results = r.Hysteresis.stabilize(annotations, r.renderMessages(ctx, annotations, data, []tinymon.Result{{
+			HostAddress: addr,
+			CheckType:   "status",
+			Status:      status,
+			Message:     msg,
+		}}))
+		if pending := r.Heartbeat.due(results, now, time.Duration(interval)*time.Second); len(pending) > 0 {
+			if err := tm.PushBulk(pending); err != nil {
+				log.Error(err, "failed to push bulk results")
+				r.reportFailed(ctx, r.Client, KindDeployment, &deploy, addr, err)
+				return ctrl.Result{}, err
+			}
+			r.Heartbeat.pushed(addr, maint, pending, now)
+		}
 	}
 
-	return ctrl.Result{RequeueAfter: time.Duration(interval) * time.Second}, nil
+	r.reportSynced(ctx, r.Client, KindDeployment, &deploy, addr, maint.results(results))
+
+	return ctrl.Result{RequeueAfter: maint.requeue(time.Duration(interval)*time.Second, now)}, nil
 }
 
-func deploymentStatus(deploy *appsv1.Deployment) (string, string) {
+// deploymentValues are the computed template values of a Deployment.
+func deploymentValues(deploy *appsv1.Deployment) map[string]any {
 	desired := int32(1)
 	if deploy.Spec.Replicas != nil {
 		desired = *deploy.Spec.Replicas
 	}
-	ready := deploy.Status.ReadyReplicas
-	available := deploy.Status.AvailableReplicas
+	return map[string]any{
+		"Replicas":          desired,
+		"ReadyReplicas":     deploy.Status.ReadyReplicas,
+		"AvailableReplicas": deploy.Status.AvailableReplicas,
+	}
+}
 
-	if ready == desired && available == desired {
-		return "ok", fmt.Sprintf("%d/%d replicas ready", ready, desired)
+// deploymentStatus rates the replicas that are both ready and available
+// against the tinymon.io/threshold.replicas annotation. Without thresholds or
+// exit thresholds, from annotations or the config, all replicas ready and
+// available is ok, no ready replica is critical and anything else a warning.
+// h applies the exit thresholds to the status check of the host at addr.
+func deploymentStatus(deploy *appsv1.Deployment, annotations map[string]string, h *Hysteresis, addr string) (string, string) {
+	desired := int32(1)
+	if deploy.Spec.Replicas != nil {
+		desired = *deploy.Spec.Replicas
 	}
-	if ready == 0 {
-		return "critical", fmt.Sprintf("0/%d replicas ready", desired)
+	ready, available := deploy.Status.ReadyReplicas, deploy.Status.AvailableReplicas
+	_, threshold := annotations[AnnotationThresholdPrefix+"replicas"]
+	_, exit := h.exitThreshold(annotations, "replicas")
+	if !threshold && !exit {
+		msg := fmt.Sprintf("%d/%d replicas ready", ready, desired)
+		switch {
+		case ready == desired && available == desired:
+			return "ok", msg
+		case ready == 0:
+			return "critical", msg
+		}
+		return "warning", msg
+	}
+
+	usable := min(ready, available)
+	msg := fmt.Sprintf("%d/%d replicas ready and available", usable, desired)
+	if desired == 0 {
+		return "ok", msg
 	}
-	return "warning", fmt.Sprintf("%d/%d replicas ready", ready, desired)
+	pct := float64(usable) / float64(desired) * 100
+	return h.thresholdStatus(annotations, addr, "status", "replicas", pct), msg
 }
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Event reasons emitted on monitored objects.
+const (
+	ReasonSynced            = "Synced"
+	ReasonDryRun            = "DryRun"
+	ReasonHostDeleted       = "HostDeleted"
+	ReasonHostDisabled      = "HostDisabled"
+	ReasonHostRetained      = "HostRetained"
+	ReasonSyncFailed        = "SyncFailed"
+	ReasonInvalidAnnotation = "InvalidAnnotation"
+	ReasonStatusCritical    = "StatusCritical"
+)
+
+// Notifier emits Kubernetes Events for sync outcomes on monitored objects.
+// Warning events are rate-limited per object and reason, so an object that
+// keeps failing doesn't flood the API server. A nil Notifier emits nothing.
+type Notifier struct {
+	recorder    events.EventRecorder
+	minInterval time.Duration
+
+	mu       sync.Mutex
+	emitted  map[string]time.Time // object UID/reason/detail -> last emitted
+	synced   map[string]bool      // addresses synced since the operator started
+	statuses map[checkKey]string  // check -> last pushed status
+}
+
+func NewNotifier(recorder events.EventRecorder, minInterval time.Duration) *Notifier {
+	return &Notifier{
+		recorder:    recorder,
+		minInterval: minInterval,
+		emitted:     make(map[string]time.Time),
+		synced:      make(map[string]bool),
+		statuses:    make(map[checkKey]string),
+	}
+}
+
+// Synced emits a Normal event the first time a host is synced since the
+// operator started.
+func (n *Notifier) Synced(obj client.Object, addr string) {
+	if n == nil || !n.firstSync(addr) {
+		return
+	}
+	n.recorder.Eventf(obj, nil, corev1.EventTypeNormal, ReasonSynced, "Sync", "Synced to TinyMon as %s", addr)
+}
+
+// DryRun is Synced in dry-run mode, where nothing is sent to TinyMon.
+func (n *Notifier) DryRun(obj client.Object, addr string) {
+	if n == nil || !n.firstSync(addr) {
+		return
+	}
+	n.recorder.Eventf(obj, nil, corev1.EventTypeNormal, ReasonDryRun, "Sync", "Would sync to TinyMon as %s, not sent in dry-run mode", addr)
+}
+
+// firstSync records that addr was synced and reports whether it is the
+// first time.
+func (n *Notifier) firstSync(addr string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	first := !n.synced[addr]
+	n.synced[addr] = true
+	return first
+}
+
+// HostRemoved emits a Normal event when monitoring was turned off for an
+// object whose host was synced before, saying what the deletion policy did
+// with the host. Deleted objects can't carry events, so for them only the
+// in-memory state is dropped (obj == nil).
+func (n *Notifier) HostRemoved(obj client.Object, addr string, policy DeletionPolicy) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	known := n.synced[addr]
+	delete(n.synced, addr)
+	for key := range n.statuses {
+		if key.address == addr {
+			delete(n.statuses, key)
+		}
+	}
+	n.mu.Unlock()
+	if !known || obj == nil {
+		return
+	}
+	switch policy {
+	case DeletionPolicyDisable:
+		n.recorder.Eventf(obj, nil, corev1.EventTypeNormal, ReasonHostDisabled, "Disable", "Disabled %s in TinyMon, its history is kept", addr)
+	case DeletionPolicyRetain:
+		n.recorder.Eventf(obj, nil, corev1.EventTypeNormal, ReasonHostRetained, "Retain", "Stopped syncing %s, the host is kept in TinyMon", addr)
+	default:
+		n.recorder.Eventf(obj, nil, corev1.EventTypeNormal, ReasonHostDeleted, "Delete", "Removed %s from TinyMon", addr)
+	}
+}
+
+// SyncFailed emits a rate-limited Warning event for a failed TinyMon call.
+func (n *Notifier) SyncFailed(obj client.Object, err error) {
+	if n == nil || !n.allow(obj, ReasonSyncFailed, "") {
+		return
+	}
+	n.recorder.Eventf(obj, nil, corev1.EventTypeWarning, ReasonSyncFailed, "Sync", "Failed to sync to TinyMon: %v", err)
+}
+
+// InvalidAnnotations emits a rate-limited Warning event for every annotation
+// that is ignored because its value is invalid.
+func (n *Notifier) InvalidAnnotations(obj client.Object, annotations map[string]string, errs map[string]error) {
+	if n == nil {
+		return
+	}
+	for key, err := range errs {
+		if !n.allow(obj, ReasonInvalidAnnotation, key+"="+annotations[key]) {
+			continue
+		}
+		n.recorder.Eventf(obj, nil, corev1.EventTypeWarning, ReasonInvalidAnnotation, "Validate", "Ignoring %s: %v", key, err)
+	}
+}
+
+// Results emits a Warning event for every check whose status changed to
+// critical since the last push.
+func (n *Notifier) Results(obj client.Object, results []tinymon.Result) {
+	if n == nil {
+		return
+	}
+	for _, res := range results {
+		key := checkKey{res.HostAddress, res.CheckType}
+		n.mu.Lock()
+		prev, seen := n.statuses[key]
+		n.statuses[key] = res.Status
+		n.mu.Unlock()
+		if res.Status != "critical" || prev == "critical" || !n.allow(obj, ReasonStatusCritical, res.CheckType) {
+			continue
+		}
+		if !seen {
+			prev = "unknown"
+		}
+		n.recorder.Eventf(obj, nil, corev1.EventTypeWarning, ReasonStatusCritical, "Check",
+			"Check %s changed from %s to critical: %s", res.CheckType, prev, res.Message)
+	}
+}
+
+// allow reports whether an event may be emitted, recording the emission.
+func (n *Notifier) allow(obj client.Object, reason, detail string) bool {
+	key := fmt.Sprintf("%s/%s/%s", obj.GetUID(), reason, detail)
+	now := time.Now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for k, t := range n.emitted {
+		if now.Sub(t) >= n.minInterval {
+			delete(n.emitted, k)
+		}
+	}
+	if _, ok := n.emitted[key]; ok {
+		return false
+	}
+	n.emitted[key] = now
+	return true
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+)
+
+// checkKey identifies a check the way TinyMon does, by host address and type.
+// Addresses may contain slashes and nest, e.g. those of TinyMonChecks, so the
+// two aren't joined into one string.
+type checkKey struct {
+	address, checkType string
+}
+
+// pushedResult is the last result pushed for a check.
+type pushedResult struct {
+	status, message string
+	at              time.Time
+}
+
+// Heartbeat pushes a result when its status or message changes and an
+// unchanged result only once per period, so TinyMon isn't sent the same
+// result on every reconcile. A nil Heartbeat pushes every result.
+type Heartbeat struct {
+	period time.Duration
+
+	mu   sync.Mutex
+	last map[checkKey]pushedResult
+}
+
+// NewHeartbeat returns a Heartbeat pushing unchanged results every period,
+// or nil if period is zero.
+func NewHeartbeat(period time.Duration) *Heartbeat {
+	if period <= 0 {
+		return nil
+	}
+	return &Heartbeat{period: period, last: make(map[checkKey]pushedResult)}
+}
+
+// interval returns the check interval in seconds for checks reconciled every
+// seconds. Unchanged results arrive only once per period, so the interval is
+// raised to it and TinyMon doesn't consider the checks stale.
+func (h *Heartbeat) interval(seconds int) int {
+	if h == nil {
+		return seconds
+	}
+	return max(seconds, int(h.period/time.Second))
+}
+
+// due returns the results to push at now: the changed ones, and all of them
+// if the heartbeat would be missed by waiting for the next reconcile in
+// requeue.
+func (h *Heartbeat) due(results []tinymon.Result, now time.Time, requeue time.Duration) []tinymon.Result {
+	if h == nil {
+		return results
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []tinymon.Result
+	for _, res := range results {
+		last, ok := h.last[checkKey{res.HostAddress, res.CheckType}]
+		if ok && last.status == res.Status && last.message == res.Message && now.Sub(last.at)+requeue <= h.period {
+			continue
+		}
+		out = append(out, res)
+	}
+	return out
+}
+
+// pushed records that results were pushed at now. While the object is in
+// maintenance nothing reaches TinyMon, so the host's results are forgotten
+// and pushed again when the maintenance ends.
+func (h *Heartbeat) pushed(addr string, m maintenance, results []tinymon.Result, now time.Time) {
+	if h == nil {
+		return
+	}
+	if m.active() {
+		h.forget(addr)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, res := range results {
+		h.last[checkKey{res.HostAddress, res.CheckType}] = pushedResult{status: res.Status, message: res.Message, at: now}
+	}
+}
+
+// forget drops the pushed results of the host at addr, so its next results
+// are pushed.
+func (h *Heartbeat) forget(addr string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key := range h.last {
+		if key.address == addr {
+			delete(h.last, key)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+)
+
+func TestHeartbeatForget(t *testing.T) {
+	now := date("2026-10-18T02:00:00Z")
+	results := []tinymon.Result{
+		{HostAddress: "k8s://prod/tinymoncheck/shop/web", CheckType: "http", Status: "ok"},
+		{HostAddress: "k8s://prod/tinymoncheck/shop/web/api", CheckType: "http", Status: "ok"},
+	}
+	h := NewHeartbeat(5 * time.Minute)
+	h.pushed("", maintenance{}, results, now)
+
+	h.forget("k8s://prod/tinymoncheck/shop/web")
+	due := h.due(results, now.Add(time.Minute), time.Minute)
+	if len(due) != 1 || due[0].HostAddress != "k8s://prod/tinymoncheck/shop/web" {
+		t.Errorf("due after forgetting the outer host = %+v, want only its result", due)
+	}
+}
@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Hysteresis annotations. They override the configured defaults.
+const (
+	// AnnotationHysteresis is the number of consecutive observations of a
+	// new status before it is pushed, e.g. "3".
+	AnnotationHysteresis = "tinymon.io/hysteresis"
+	// AnnotationExitThresholdPrefix is followed by a check type, e.g.
+	// "tinymon.io/threshold-exit.load: 70,85". A warning or critical status
+	// is kept until the value crosses back over these thresholds.
+	AnnotationExitThresholdPrefix = "tinymon.io/threshold-exit."
+)
+
+var hysteresisLog = ctrl.Log.WithName("hysteresis")
+
+// maxObservations bounds tinymon.io/hysteresis, so a change isn't held back
+// for hours.
+const maxObservations = 100
+
+// HysteresisConfig holds the defaults for objects without the hysteresis
+// annotations.
+type HysteresisConfig struct {
+	// Observations is the number of consecutive observations of a new
+	// status before it is pushed. 0 or 1 pushes every change.
+	Observations int `json:"observations,omitempty"`
+	// ExitThresholds are the exit thresholds per check type, in the form of
+	// tinymon.io/threshold-exit.<type>.
+	ExitThresholds map[string]string `json:"exitThresholds,omitempty"`
+}
+
+// exitThreshold is a parsed exit threshold. Without a critical exit
+// threshold, critical is left at the critical threshold.
+type exitThreshold struct {
+	warn, crit float64
+	hasCrit    bool
+}
+
+// checkState is the status history of a check.
+type checkState struct {
+	// reported and message are the status and message last reported to
+	// TinyMon.
+	reported, message string
+	// pending is a different status observed count times in a row.
+	pending string
+	count   int
+}
+
+// Hysteresis remembers the status reported for every check, holds back
+// status changes until they are confirmed and applies exit thresholds. A nil
+// Hysteresis reports every status as computed.
+type Hysteresis struct {
+	observations int
+	exit         map[string]exitThreshold
+
+	mu     sync.Mutex
+	checks map[checkKey]*checkState
+}
+
+// NewHysteresis validates cfg.
+func NewHysteresis(cfg HysteresisConfig) (*Hysteresis, error) {
+	h := &Hysteresis{
+		observations: 1,
+		exit:         make(map[string]exitThreshold, len(cfg.ExitThresholds)),
+		checks:       make(map[checkKey]*checkState),
+	}
+	if cfg.Observations != 0 {
+		if cfg.Observations < 1 || cfg.Observations > maxObservations {
+			return nil, fmt.Errorf("hysteresis.observations: %d is not between 1 and %d", cfg.Observations, maxObservations)
+		}
+		h.observations = cfg.Observations
+	}
+	for checkType, v := range cfg.ExitThresholds {
+		exit, err := parseExitThreshold(checkType, v)
+		if err != nil {
+			return nil, fmt.Errorf("hysteresis.exitThresholds.%s: %w", checkType, err)
+		}
+		h.exit[checkType] = exit
+	}
+	return h, nil
+}
+
+// parseObservations parses the value of tinymon.io/hysteresis.
+func parseObservations(v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxObservations {
+		return 0, fmt.Errorf("%q is not a number of observations between 1 and %d", v, maxObservations)
+	}
+	return n, nil
+}
+
+// parseExitThreshold parses an exit threshold of checkType, which takes the
+// same form as its threshold.
+func parseExitThreshold(checkType, v string) (exitThreshold, error) {
+	warn, crit, err := parseThreshold(checkType, v)
+	if err != nil {
+		return exitThreshold{}, err
+	}
+	return exitThreshold{warn: warn, crit: crit, hasCrit: strings.Contains(v, ",")}, nil
+}
+
+// thresholdStatus returns the status of value for thresholdType like
+// thresholdStatus, but keeps a warning or critical status reported for the
+// check at addr until value crosses back over the exit thresholds.
+func (h *Hysteresis) thresholdStatus(annotations map[string]string, addr, checkType, thresholdType string, value float64) string {
+	status := thresholdStatus(annotations, thresholdType, value)
+	previous := h.reported(addr, checkType)
+	if statusRank(previous) <= statusRank(status) {
+		return status
+	}
+	exit, ok := h.exitThreshold(annotations, thresholdType)
+	if !ok {
+		return status
+	}
+
+	// Exit thresholds beyond the thresholds would leave a status before it
+	// is entered, so they are capped.
+	warn, crit := thresholds(annotations, thresholdType)
+	lowerIsWorse := thresholdSpecs[thresholdType].lowerIsWorse
+	exitWarn, exitCrit := capExit(exit.warn, warn, lowerIsWorse), crit
+	if exit.hasCrit {
+		exitCrit = capExit(exit.crit, crit, lowerIsWorse)
+	}
+	if lowerIsWorse {
+		switch {
+		case previous == "critical" && value <= exitCrit:
+			return "critical"
+		case value < exitWarn:
+			return "warning"
+		}
+		return status
+	}
+	switch {
+	case previous == "critical" && value >= exitCrit:
+		return "critical"
+	case value >= exitWarn:
+		return "warning"
+	}
+	return status
+}
+
+// capExit returns exit, or threshold if exit is worse than threshold.
+func capExit(exit, threshold float64, lowerIsWorse bool) float64 {
+	if lowerIsWorse {
+		return max(exit, threshold)
+	}
+	return min(exit, threshold)
+}
+
+// statusRank orders the statuses thresholds produce, other statuses rank
+// lowest so they are never kept.
+func statusRank(status string) int {
+	switch status {
+	case "warning":
+		return 1
+	case "critical":
+		return 2
+	}
+	return 0
+}
+
+// exitThreshold returns the exit threshold of thresholdType set by
+// annotation, else the configured one. Invalid annotations are ignored,
+// they are reported by validateAnnotations.
+func (h *Hysteresis) exitThreshold(annotations map[string]string, thresholdType string) (exitThreshold, bool) {
+	if v, ok := annotations[AnnotationExitThresholdPrefix+thresholdType]; ok {
+		if exit, err := parseExitThreshold(thresholdType, v); err == nil {
+			return exit, true
+		}
+	}
+	if h == nil {
+		return exitThreshold{}, false
+	}
+	exit, ok := h.exit[thresholdType]
+	return exit, ok
+}
+
+// reported returns the status last reported for the check at addr.
+func (h *Hysteresis) reported(addr, checkType string) string {
+	if h == nil {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if st, ok := h.checks[checkKey{addr, checkType}]; ok {
+		return st.reported
+	}
+	return ""
+}
+
+// stabilize holds back status changes of results until they have been
+// observed tinymon.io/hysteresis times in a row, reporting the previous
+// status and message unchanged meanwhile, so the heartbeat doesn't push
+// them, and records the statuses reported. The first status of a check is
+// reported immediately.
+func (h *Hysteresis) stabilize(annotations map[string]string, results []tinymon.Result) []tinymon.Result {
+	if h == nil {
+		return results
+	}
+	n := h.observations
+	if v, ok := annotations[AnnotationHysteresis]; ok {
+		if i, err := parseObservations(v); err == nil {
+			n = i
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]tinymon.Result, len(results))
+	for i, res := range results {
+		key := checkKey{res.HostAddress, res.CheckType}
+		st, ok := h.checks[key]
+		switch {
+		case !ok:
+			h.checks[key] = &checkState{reported: res.Status, message: res.Message}
+		case res.Status == st.reported:
+			st.message, st.pending, st.count = res.Message, "", 0
+		default:
+			if res.Status == st.pending {
+				st.count++
+			} else {
+				st.pending, st.count = res.Status, 1
+			}
+			if st.count >= n {
+				st.reported, st.message, st.pending, st.count = res.Status, res.Message, "", 0
+				break
+			}
+			hysteresisLog.V(1).Info("status change pending", "address", res.HostAddress, "checkType", res.CheckType,
+				"reported", st.reported, "pending", st.pending, "observations", st.count, "required", n, "message", res.Message)
+			res.Status, res.Message = st.reported, st.message
+		}
+		out[i] = res
+	}
+	return out
+}
+
+// forget drops the status history of the checks of the host at addr.
+func (h *Hysteresis) forget(addr string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key := range h.checks {
+		if key.address == addr {
+			delete(h.checks, key)
+		}
+	}
+}
@@ -2,77 +2,137 @@ package controller
 
 import (
 	"context"
-	"strconv"
-	"time"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
+	tinymonv1alpha1 "github.com/unclesamwk/tinymon-operator/api/v1alpha1"
 	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
 
+	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 type IngressReconciler struct {
 	client.Client
-	TinyMon *tinymon.Client
-	Cluster string
+	// APIReader reads objects watched metadata-only in full.
+	APIReader client.Reader
+	TinyMon   tinymon.API
+	Options
 }
 
-func SetupIngressReconciler(mgr ctrl.Manager, tm *tinymon.Client, cluster string) error {
+func SetupIngressReconciler(mgr ctrl.Manager, tm tinymon.API, opts Options) error {
+	list := opts.listType(mgr.GetScheme(), &networkingv1.IngressList{})
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&networkingv1.Ingress{}).
-		Complete(&IngressReconciler{Client: mgr.GetClient(), TinyMon: tm, Cluster: cluster})
+		For(&networkingv1.Ingress{}, opts.forOptions(&networkingv1.Ingress{})...).
+		Watches(&corev1.Namespace{}, namespaceHandler(mgr.GetClient(), list), builder.WithPredicates(namespaceDefaultsChanged)).
+		Watches(&tinymonv1alpha1.MonitoringPolicy{}, policyHandler(mgr.GetClient(), list), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WatchesRawSource(opts.rebalanceSource(mgr.GetClient(), list)).
+		Complete(opts.reconciler("ingress", &IngressReconciler{Client: mgr.GetClient(), APIReader: mgr.GetAPIReader(), TinyMon: tm, Options: opts}))
 }
 
 func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx).WithValues("ingress", req.NamespacedName)
 
 	var ingress networkingv1.Ingress
-	if err := r.Get(ctx, req.NamespacedName, &ingress); err != nil {
+	if err := r.getObject(ctx, r.Client, r.APIReader, KindIngress, req.NamespacedName, &ingress); err != nil {
 		if errors.IsNotFound(err) {
-			log.Info("ingress deleted, removing from TinyMon")
+			policy := r.deletionPolicy(nil)
+			log.Info("ingress deleted, removing from TinyMon", "deletionPolicy", policy)
 			addr := resourceAddress(r.Cluster, "ingress", req.Namespace, req.Name)
-			_ = r.TinyMon.DeleteHost(addr)
+			api, err := r.tinymon(ctx, r.Client, r.TinyMon, req.Namespace)
+			if err != nil {
+				log.Error(err, "failed to resolve TinyMon credentials")
+				return ctrl.Result{}, err
+			}
+			host := r.deletedHost(addr, req.Name, fmt.Sprintf("Ingress %s/%s", req.Namespace, req.Name), "ingresses", req.Namespace, ingressType(nil))
+			if err := r.removeHost(api, policy, host); err != nil {
+				log.Error(err, "failed to remove host")
+				return ctrl.Result{}, err
+			}
+			r.reportRemoved(ctx, r.Client, nil, addr, policy)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
 
-	if !isEnabled(ingress.Annotations) {
-		addr := resourceAddress(r.Cluster, "ingress", ingress.Namespace, ingress.Name)
-		_ = r.TinyMon.DeleteHost(addr)
-		return ctrl.Result{}, nil
+	annotations, labels, err := effectiveMetadata(ctx, r.Client, KindIngress, &ingress)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
 	addr := resourceAddress(r.Cluster, "ingress", ingress.Namespace, ingress.Name)
-	httpInterval := checkInterval(ingress.Annotations, 300)
-	certInterval := checkInterval(ingress.Annotations, 3600)
-	t := defaultTopic(r.Cluster, "ingresses", ingress.Namespace, ingress.Annotations)
-	expectedStatus := expectedStatusCode(ingress.Annotations)
+	api, err := r.tinymon(ctx, r.Client, r.TinyMon, ingress.Namespace)
+	if err != nil {
+		log.Error(err, "failed to resolve TinyMon credentials")
+		r.reportFailed(ctx, r.Client, KindIngress, &ingress, addr, err)
+		return ctrl.Result{}, err
+	}
+	httpInterval := checkInterval(annotations, 300)
+	certInterval := checkInterval(annotations, 3600)
+	expectedStatus := expectedStatusCode(annotations)
 
 	hosts := ingressHosts(&ingress)
+	class := ""
+	if ingress.Spec.IngressClassName != nil {
+		class = *ingress.Spec.IngressClassName
+	}
+	data := r.templateData(ctx, r.Client, KindIngress, &ingress, annotations, map[string]any{
+		"Hosts": hosts,
+		"Class": class,
+		"Type":  ingressType(annotations),
+	})
 	host := tinymon.Host{
-		Name:        displayName(ingress.Annotations, ingress.Name),
+		Name:        r.hostName(ctx, annotations, data),
 		Address:     addr,
-		Description: fmt.Sprintf("Ingress %s/%s (%s)", ingress.Namespace, ingress.Name, strings.Join(hosts, ", ")),
-		Topic:       t,
-		Labels:      buildLabels(r.Cluster, ingressType(ingress.Annotations), ingress.Labels),
+		Description: r.hostDescription(ctx, annotations, data, fmt.Sprintf("Ingress %s/%s (%s)", ingress.Namespace, ingress.Name, strings.Join(hosts, ", "))),
+		Topic:       r.hostTopic(ctx, annotations, data, defaultTopic(r.Cluster, "ingresses", ingress.Namespace, annotations)),
+		Labels:      r.hostLabels(ingressType(annotations), &ingress, data, labels),
 		Enabled:     1,
 	}
 
+	if !isEnabled(annotations) {
+		policy := r.deletionPolicy(annotations)
+		if err := r.removeHost(api, policy, host); err != nil {
+			log.Error(err, "failed to remove host")
+			return ctrl.Result{}, err
+		}
+		r.reportRemoved(ctx, r.Client, &ingress, addr, policy)
+		return ctrl.Result{}, nil
+	}
+
+	r.reportAnnotations(KindIngress, &ingress, annotations)
+
+	now := time.Now()
+	maint := maintenanceState(annotations, now)
+	tm := maint.api(api)
+	if maint.active() {
+		log.Info("in maintenance, checks disabled", "until", maint.until)
+	}
+
 	log.Info("syncing ingress to TinyMon", "address", addr)
-	if err := r.TinyMon.UpsertHost(host); err != nil {
+	if err := tm.UpsertHost(host); err != nil {
 		log.Error(err, "failed to upsert host")
+		r.reportFailed(ctx, r.Client, KindIngress, &ingress, addr, err)
 		return ctrl.Result{}, err
 	}
 
-	// Create pull checks (TinyMon executes these, no result push from operator)
+	// Create pull checks (TinyMon executes these, no result push from operator).
+	// A failed check doesn't stop the others, the last error is reported.
+	checks := selectedChecks(annotations)
+	syncErr := r.removeDeselectedChecks(tm, addr, []string{"http", "certificate", "icecast_listeners"}, checks)
+	if syncErr != nil {
+		log.Error(syncErr, "failed to delete deselected checks")
+	}
 	httpPath := ""
-	if p, ok := ingress.Annotations[AnnotationHTTPPath]; ok && p != "" {
+	if p, ok := annotations[AnnotationHTTPPath]; ok && p != "" {
 		httpPath = strings.TrimRight(p, "/")
 		if !strings.HasPrefix(httpPath, "/") {
 			httpPath = "/" + httpPath
@@ -92,10 +152,16 @@ func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			IntervalSeconds: httpInterval,
 			Enabled:         1,
 		}
-		if err := r.TinyMon.UpsertCheck(check); err != nil {
-			log.Error(err, "failed to upsert http check", "host", h)
+		if checks.enabled("http") {
+			if err := tm.UpsertCheck(check); err != nil {
+				log.Error(err, "failed to upsert http check", "host", h)
+				syncErr = err
+			}
 		}
 
+		if !checks.enabled("certificate") {
+			continue
+		}
 		for _, tls := range ingress.Spec.TLS {
 			for _, tlsHost := range tls.Hosts {
 				if tlsHost == h {
@@ -106,8 +172,9 @@ func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 						IntervalSeconds: certInterval,
 						Enabled:         1,
 					}
-					if err := r.TinyMon.UpsertCheck(certCheck); err != nil {
+					if err := tm.UpsertCheck(certCheck); err != nil {
 						log.Error(err, "failed to upsert certificate check", "host", h)
+						syncErr = err
 					}
 				}
 			}
@@ -115,7 +182,7 @@ func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	}
 
 	// Create icecast_listeners checks if annotation is set (pull mode)
-	if mounts, ok := ingress.Annotations[AnnotationIcecastMounts]; ok && mounts != "" {
+	if mounts, ok := annotations[AnnotationIcecastMounts]; ok && mounts != "" && checks.enabled("icecast_listeners") {
 		for _, mount := range strings.Split(mounts, ",") {
 			mount = strings.TrimSpace(mount)
 			if mount == "" {
@@ -129,14 +196,21 @@ func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 					IntervalSeconds: httpInterval,
 					Enabled:         1,
 				}
-				if err := r.TinyMon.UpsertCheck(iceCheck); err != nil {
+				if err := tm.UpsertCheck(iceCheck); err != nil {
 					log.Error(err, "failed to upsert icecast check", "host", h, "mount", mount)
+					syncErr = err
 				}
 			}
 		}
 	}
 
-	return ctrl.Result{RequeueAfter: time.Duration(httpInterval) * time.Second}, nil
+	if syncErr != nil {
+		r.reportFailed(ctx, r.Client, KindIngress, &ingress, addr, syncErr)
+	} else {
+		r.reportSynced(ctx, r.Client, KindIngress, &ingress, addr, nil)
+	}
+
+	return ctrl.Result{RequeueAfter: maint.requeue(time.Duration(httpInterval)*time.Second, now)}, nil
 }
 
 func expectedStatusCode(annotations map[string]string) int {
@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Facts derived from objects that label mappings can copy.
+const (
+	FactOwnerKind    = "ownerKind"
+	FactNodeName     = "nodeName"
+	FactStorageClass = "storageClass"
+	FactIngressClass = "ingressClass"
+	FactNamespace    = "namespace"
+	FactKind         = "kind"
+)
+
+var knownFacts = map[string]bool{
+	FactOwnerKind:    true,
+	FactNodeName:     true,
+	FactStorageClass: true,
+	FactIngressClass: true,
+	FactNamespace:    true,
+	FactKind:         true,
+}
+
+// LabelMapping copies a Kubernetes label, annotation, namespace label or
+// derived fact to a TinyMon host label. Exactly one source is set.
+type LabelMapping struct {
+	Label          string `json:"label,omitempty"`
+	Annotation     string `json:"annotation,omitempty"`
+	NamespaceLabel string `json:"namespaceLabel,omitempty"`
+	Fact           string `json:"fact,omitempty"`
+
+	// To is the TinyMon label. Defaults to the source key.
+	To string `json:"to,omitempty"`
+	// Regex and Replacement rewrite the value, e.g. "^team-(.*)$" and "$1".
+	// Values that don't match are copied unchanged.
+	Regex       string `json:"regex,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+	// Case converts the value to lower or upper case.
+	Case string `json:"case,omitempty"`
+	// Default is used if the source is missing or empty. Without it, the
+	// TinyMon label is left out.
+	Default string `json:"default,omitempty"`
+	// Kinds restricts the mapping to some kinds. Empty applies it to all.
+	Kinds []string `json:"kinds,omitempty"`
+}
+
+// labelMapping is a validated LabelMapping.
+type labelMapping struct {
+	source string // label, annotation, namespaceLabel or fact
+	key    string
+	LabelMapping
+	regex *regexp.Regexp
+	kinds map[string]bool
+}
+
+// LabelMapper applies the configured label mappings. A nil LabelMapper maps
+// nothing.
+type LabelMapper struct {
+	mappings []labelMapping
+}
+
+// NewLabelMapper validates the label mappings.
+func NewLabelMapper(mappings []LabelMapping) (*LabelMapper, error) {
+	m := &LabelMapper{}
+	for i, lm := range mappings {
+		var sources []labelMapping
+		for _, s := range []struct{ source, key string }{
+			{"label", lm.Label},
+			{"annotation", lm.Annotation},
+			{"namespaceLabel", lm.NamespaceLabel},
+			{"fact", lm.Fact},
+		} {
+			if s.key != "" {
+				sources = append(sources, labelMapping{source: s.source, key: s.key})
+			}
+		}
+		if len(sources) != 1 {
+			return nil, fmt.Errorf("labels[%d]: exactly one of label, annotation, namespaceLabel or fact must be set", i)
+		}
+		mapping := sources[0]
+		mapping.LabelMapping = lm
+		if mapping.source == "fact" && !knownFacts[mapping.key] {
+			return nil, fmt.Errorf("labels[%d]: unknown fact %q", i, mapping.key)
+		}
+		if mapping.To == "" {
+			mapping.To = mapping.key
+		}
+		if lm.Regex != "" {
+			re, err := regexp.Compile(lm.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("labels[%d]: invalid regex: %w", i, err)
+			}
+			mapping.regex = re
+		}
+		if lm.Case != "" && lm.Case != "lower" && lm.Case != "upper" {
+			return nil, fmt.Errorf("labels[%d]: case must be lower or upper", i)
+		}
+		for _, kind := range lm.Kinds {
+			if _, ok := policyListTypes[kind]; !ok && kind != KindTinyMonCheck {
+				return nil, fmt.Errorf("labels[%d]: unknown kind %q", i, kind)
+			}
+			if mapping.kinds == nil {
+				mapping.kinds = make(map[string]bool)
+			}
+			mapping.kinds[kind] = true
+		}
+		m.mappings = append(m.mappings, mapping)
+	}
+	return m, nil
+}
+
+// apply returns the TinyMon labels mapped from the object described by data.
+func (m *LabelMapper) apply(data TemplateData, facts map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string]string)
+	for _, mapping := range m.mappings {
+		if mapping.kinds != nil && !mapping.kinds[data.Kind] {
+			continue
+		}
+		var v string
+		switch mapping.source {
+		case "label":
+			v = data.Labels[mapping.key]
+		case "annotation":
+			v = data.Annotations[mapping.key]
+		case "namespaceLabel":
+			v = data.NamespaceLabels[mapping.key]
+		case "fact":
+			v = facts[mapping.key]
+		}
+		if v != "" && mapping.regex != nil {
+			v = mapping.regex.ReplaceAllString(v, mapping.Replacement)
+		}
+		switch mapping.Case {
+		case "lower":
+			v = strings.ToLower(v)
+		case "upper":
+			v = strings.ToUpper(v)
+		}
+		if v == "" {
+			v = mapping.Default
+		}
+		if v != "" {
+			result[mapping.To] = v
+		}
+	}
+	return result
+}
+
+// objectFacts derives the facts label mappings can copy from obj.
+func objectFacts(kind string, obj client.Object) map[string]string {
+	facts := map[string]string{
+		FactKind:      kind,
+		FactNamespace: obj.GetNamespace(),
+	}
+	if owner := metav1.GetControllerOf(obj); owner != nil {
+		facts[FactOwnerKind] = owner.Kind
+	} else if owners := obj.GetOwnerReferences(); len(owners) > 0 {
+		facts[FactOwnerKind] = owners[0].Kind
+	}
+	switch o := obj.(type) {
+	case *corev1.Node:
+		facts[FactNodeName] = o.Name
+	case *corev1.PersistentVolumeClaim:
+		facts[FactNodeName] = o.Annotations["volume.kubernetes.io/selected-node"]
+		if o.Spec.StorageClassName != nil {
+			facts[FactStorageClass] = *o.Spec.StorageClassName
+		}
+	case *networkingv1.Ingress:
+		if o.Spec.IngressClassName != nil {
+			facts[FactIngressClass] = *o.Spec.IngressClassName
+		} else {
+			facts[FactIngressClass] = o.Annotations["kubernetes.io/ingress.class"]
+		}
+	}
+	return facts
+}
+
+// hostLabels returns the TinyMon labels of obj: cluster and type, then the
+// configured label mappings, then the tinymon.io/label- labels, each
+// overriding the previous ones.
+func (o Options) hostLabels(hostType string, obj client.Object, data TemplateData, labels map[string]string) map[string]string {
+	result := buildLabels(o.Cluster, hostType, nil)
+	for k, v := range o.LabelMappings.apply(data, objectFacts(data.Kind, obj)) {
+		result[k] = v
+	}
+	for k, v := range extractLabels(labels) {
+		result[k] = v
+	}
+	return result
+}
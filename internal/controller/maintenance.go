@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+)
+
+// Maintenance annotations. While an object is in maintenance its host is
+// kept, its checks are disabled and no results are pushed.
+const (
+	// AnnotationMaintenanceUntil is an RFC3339 time until which the object
+	// is in maintenance.
+	AnnotationMaintenanceUntil = "tinymon.io/maintenance-until"
+	// AnnotationMaintenanceWindow is a recurring window, a cron expression
+	// for the start followed by the duration, e.g. "0 2 * * 0 2h". Several
+	// windows are separated by ";".
+	AnnotationMaintenanceWindow = "tinymon.io/maintenance-window"
+)
+
+// maxWindowDuration bounds the duration of a recurring window, which keeps
+// finding the current window cheap.
+const maxWindowDuration = 7 * 24 * time.Hour
+
+// maintenance is the maintenance state of an object at a point in time.
+type maintenance struct {
+	// until is the end of the current maintenance, zero if there is none.
+	until time.Time
+	// next is the start of the next recurring window, zero if unknown.
+	next time.Time
+}
+
+// maintenanceState evaluates the maintenance annotations at now. Invalid
+// values are ignored, they are reported by validateAnnotations.
+func maintenanceState(annotations map[string]string, now time.Time) maintenance {
+	var m maintenance
+	if v, ok := annotations[AnnotationMaintenanceUntil]; ok {
+		if t, err := time.Parse(time.RFC3339, v); err == nil && now.Before(t) {
+			m.until = t
+		}
+	}
+	if v, ok := annotations[AnnotationMaintenanceWindow]; ok {
+		windows, _ := parseMaintenanceWindows(v)
+		for _, w := range windows {
+			if end := w.activeUntil(now); end.After(m.until) {
+				m.until = end
+			}
+			if start := w.nextStart(now); !start.IsZero() && (m.next.IsZero() || start.Before(m.next)) {
+				m.next = start
+			}
+		}
+	}
+	return m
+}
+
+// active reports whether the object is in maintenance.
+func (m maintenance) active() bool {
+	return !m.until.IsZero()
+}
+
+// api returns tm, or an API that disables checks and drops results while
+// the object is in maintenance.
+func (m maintenance) api(tm tinymon.API) tinymon.API {
+	if !m.active() {
+		return tm
+	}
+	return maintenanceAPI{tm}
+}
+
+// results returns the results to report, none while in maintenance.
+func (m maintenance) results(results []tinymon.Result) []tinymon.Result {
+	if m.active() {
+		return nil
+	}
+	return results
+}
+
+// requeue shortens d so the object is reconciled when the maintenance ends
+// or the next window starts.
+func (m maintenance) requeue(d time.Duration, now time.Time) time.Duration {
+	for _, t := range []time.Time{m.until, m.next} {
+		if t.IsZero() {
+			continue
+		}
+		if until := t.Sub(now) + time.Second; until > 0 && until < d {
+			d = until
+		}
+	}
+	return d
+}
+
+// maintenanceAPI upserts checks disabled and drops results.
+type maintenanceAPI struct {
+	tinymon.API
+}
+
+func (m maintenanceAPI) UpsertCheck(check tinymon.Check) error {
+	check.Enabled = 0
+	return m.API.UpsertCheck(check)
+}
+
+func (m maintenanceAPI) PushResult(tinymon.Result) error {
+	return nil
+}
+
+func (m maintenanceAPI) PushBulk([]tinymon.Result) error {
+	return nil
+}
+
+// maintenanceWindow is a recurring maintenance window.
+type maintenanceWindow struct {
+	schedule cronSchedule
+	duration time.Duration
+}
+
+// parseMaintenanceWindows parses "<minute> <hour> <day> <month> <weekday>
+// <duration>" entries separated by ";".
+func parseMaintenanceWindows(v string) ([]maintenanceWindow, error) {
+	var windows []maintenanceWindow
+	for _, entry := range strings.Split(v, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Fields(entry)
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("%q is not a cron expression followed by a duration, e.g. \"0 2 * * 0 2h\"", entry)
+		}
+		schedule, err := parseCron(fields[:5])
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", entry, err)
+		}
+		d, err := time.ParseDuration(fields[5])
+		if err != nil || d <= 0 || d > maxWindowDuration {
+			return nil, fmt.Errorf("%q: duration %q must be positive and at most %s", entry, fields[5], maxWindowDuration)
+		}
+		windows = append(windows, maintenanceWindow{schedule: schedule, duration: d})
+	}
+	return windows, nil
+}
+
+// activeUntil returns the end of the window containing now, or zero.
+func (w maintenanceWindow) activeUntil(now time.Time) time.Time {
+	t := now.Truncate(time.Minute)
+	for earliest := now.Add(-w.duration); !t.Before(earliest); t = t.Add(-time.Minute) {
+		if w.schedule.matches(t) {
+			return t.Add(w.duration)
+		}
+	}
+	return time.Time{}
+}
+
+// nextStart returns the next start of the window within a week, or zero.
+func (w maintenanceWindow) nextStart(now time.Time) time.Time {
+	t := now.Truncate(time.Minute).Add(time.Minute)
+	for latest := now.Add(maxWindowDuration); t.Before(latest); t = t.Add(time.Minute) {
+		if w.schedule.matches(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// cronSchedule is a standard five-field cron expression, evaluated in UTC.
+type cronSchedule struct {
+	minute, hour, day, month, weekday map[int]bool
+	// dayRestricted and weekdayRestricted follow cron: if both fields are
+	// restricted, a time matches if either matches.
+	dayRestricted, weekdayRestricted bool
+}
+
+func parseCron(fields []string) (cronSchedule, error) {
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return s, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return s, fmt.Errorf("hour: %w", err)
+	}
+	if s.day, err = parseCronField(fields[2], 1, 31); err != nil {
+		return s, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return s, fmt.Errorf("month: %w", err)
+	}
+	if s.weekday, err = parseCronField(fields[4], 0, 7); err != nil {
+		return s, fmt.Errorf("day of week: %w", err)
+	}
+	if s.weekday[7] {
+		s.weekday[0] = true
+	}
+	s.dayRestricted = fields[2] != "*"
+	s.weekdayRestricted = fields[4] != "*"
+	return s, nil
+}
+
+// parseCronField parses lists of "*", "n", "n-m", each optionally with
+// "/step".
+func parseCronField(field string, lo, hi int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+		start, end := lo, hi
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if start, err = strconv.Atoi(from); err != nil {
+				return nil, fmt.Errorf("invalid value %q", rng)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(to); err != nil {
+					return nil, fmt.Errorf("invalid value %q", rng)
+				}
+			} else if hasStep {
+				end = hi
+			}
+		}
+		if start < lo || end > hi || start > end {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, lo, hi)
+		}
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (s cronSchedule) matches(t time.Time) bool {
+	t = t.UTC()
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	day, weekday := s.day[t.Day()], s.weekday[int(t.Weekday())]
+	if s.dayRestricted && s.weekdayRestricted {
+		return day || weekday
+	}
+	return day && weekday
+}
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "0 2 * * 0"},
+		{expr: "*/15 0-6 1,15 * 1-5"},
+		{expr: "0 2 * * 7"},
+		{expr: "60 2 * * *", wantErr: true},
+		{expr: "0 24 * * *", wantErr: true},
+		{expr: "0 2 0 * *", wantErr: true},
+		{expr: "0 2 * 13 *", wantErr: true},
+		{expr: "0 2 * * 8", wantErr: true},
+		{expr: "*/0 2 * * *", wantErr: true},
+		{expr: "0 5-1 * * *", wantErr: true},
+		{expr: "0 x * * *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseCron(strings.Fields(tt.expr))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseCron(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronScheduleMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		at   string
+		want bool
+	}{
+		{name: "weekday 0 is Sunday", expr: "0 2 * * 0", at: "2026-10-18T02:00:00Z", want: true},
+		{name: "weekday 7 is Sunday", expr: "0 2 * * 7", at: "2026-10-18T02:00:00Z", want: true},
+		{name: "weekday 7 is not Saturday", expr: "0 2 * * 7", at: "2026-10-17T02:00:00Z"},
+		{name: "other minute", expr: "0 2 * * 0", at: "2026-10-18T02:01:00Z"},
+		{name: "day and weekday, day matches", expr: "0 2 1 * 1", at: "2026-10-01T02:00:00Z", want: true},
+		{name: "day and weekday, weekday matches", expr: "0 2 1 * 1", at: "2026-10-19T02:00:00Z", want: true},
+		{name: "day and weekday, neither matches", expr: "0 2 1 * 1", at: "2026-10-20T02:00:00Z"},
+		{name: "day only", expr: "0 2 1 * *", at: "2026-10-19T02:00:00Z"},
+		{name: "weekday only", expr: "0 2 * * 1", at: "2026-10-01T02:00:00Z"},
+		{name: "step", expr: "*/15 * * * *", at: "2026-10-18T07:45:00Z", want: true},
+		{name: "evaluated in UTC", expr: "0 2 * * *", at: "2026-10-18T04:00:00+02:00", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(strings.Fields(tt.expr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.matches(date(tt.at)); got != tt.want {
+				t.Errorf("%q matches %s = %v, want %v", tt.expr, tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		window     string
+		now        string
+		wantActive string // end of the current window, empty if none
+		wantNext   string // next start, empty if none within a week
+	}{
+		{
+			name:     "before the window",
+			window:   "0 2 * * 0 2h",
+			now:      "2026-10-18T01:30:00Z",
+			wantNext: "2026-10-18T02:00:00Z",
+		},
+		{
+			name:       "in the window",
+			window:     "0 2 * * 0 2h",
+			now:        "2026-10-18T03:59:00Z",
+			wantActive: "2026-10-18T04:00:00Z",
+			wantNext:   "2026-10-25T02:00:00Z",
+		},
+		{
+			name:     "after the window",
+			window:   "0 2 * * 0 2h",
+			now:      "2026-10-18T04:30:00Z",
+			wantNext: "2026-10-25T02:00:00Z",
+		},
+		{
+			name:       "across midnight",
+			window:     "0 23 * * * 2h",
+			now:        "2026-10-18T00:30:00Z",
+			wantActive: "2026-10-18T01:00:00Z",
+			wantNext:   "2026-10-18T23:00:00Z",
+		},
+		{
+			name:       "across midnight into another weekday",
+			window:     "30 23 * * 6 1h",
+			now:        "2026-10-18T00:15:00Z",
+			wantActive: "2026-10-18T00:30:00Z",
+			wantNext:   "2026-10-24T23:30:00Z",
+		},
+		{
+			name:     "across midnight, after the window",
+			window:   "30 23 * * 6 1h",
+			now:      "2026-10-18T00:45:00Z",
+			wantNext: "2026-10-24T23:30:00Z",
+		},
+		{
+			name:   "no start within a week",
+			window: "0 2 29 2 * 1h",
+			now:    "2026-10-18T00:00:00Z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, err := parseMaintenanceWindows(tt.window)
+			if err != nil || len(windows) != 1 {
+				t.Fatalf("parseMaintenanceWindows(%q) = %v, %v", tt.window, windows, err)
+			}
+			w, now := windows[0], date(tt.now)
+			if got := w.activeUntil(now); !sameTime(got, tt.wantActive) {
+				t.Errorf("activeUntil(%s) = %v, want %q", tt.now, got, tt.wantActive)
+			}
+			if got := w.nextStart(now); !sameTime(got, tt.wantNext) {
+				t.Errorf("nextStart(%s) = %v, want %q", tt.now, got, tt.wantNext)
+			}
+		})
+	}
+}
+
+// sameTime reports whether got is the time want, or zero if want is empty.
+func sameTime(got time.Time, want string) bool {
+	if want == "" {
+		return got.IsZero()
+	}
+	return got.Equal(date(want))
+}
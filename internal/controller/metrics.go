@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// knownStatuses are the values of the status label of tinymon_check_status.
+var knownStatuses = []string{"ok", "warning", "critical", "unknown"}
+
+var (
+	managedHosts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tinymon_managed_hosts",
+		Help: "Number of hosts managed in TinyMon by cluster, kind and namespace.",
+	}, []string{"cluster", "kind", "namespace"})
+
+	checkStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tinymon_check_status",
+		Help: "Last status computed for a check, 1 for the current status and 0 for the others.",
+	}, []string{"cluster", "address", "check", "status"})
+
+	lastPush = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tinymon_last_successful_sync_timestamp_seconds",
+		Help: "Unix time of the last successful sync of a host to TinyMon.",
+	}, []string{"cluster", "address"})
+
+	syncErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tinymon_sync_errors_total",
+		Help: "Number of failed syncs to TinyMon by kind.",
+	}, []string{"kind"})
+
+	annotationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tinymon_annotation_errors_total",
+		Help: "Number of invalid tinymon.io annotations found while reconciling, by kind and annotation.",
+	}, []string{"kind", "annotation"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(managedHosts, checkStatus, lastPush, syncErrors, annotationErrors)
+}
+
+// hostKey identifies the cluster, kind and namespace a managed host is
+// counted under.
+type hostKey struct {
+	cluster   string
+	kind      string
+	namespace string
+}
+
+// hostTracker keeps the set of managed hosts to derive tinymon_managed_hosts.
+type hostTracker struct {
+	mu    sync.Mutex
+	hosts map[string]hostKey // address -> cluster/kind/namespace
+}
+
+var trackedHosts = &hostTracker{hosts: make(map[string]hostKey)}
+
+func (t *hostTracker) add(addr string, key hostKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if old, ok := t.hosts[addr]; ok && old == key {
+		return
+	}
+	t.hosts[addr] = key
+	t.update()
+}
+
+func (t *hostTracker) remove(addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.hosts[addr]; !ok {
+		return
+	}
+	delete(t.hosts, addr)
+	t.update()
+}
+
+// update recomputes the gauge. Callers must hold t.mu.
+func (t *hostTracker) update() {
+	counts := make(map[hostKey]int)
+	for _, key := range t.hosts {
+		counts[key]++
+	}
+	managedHosts.Reset()
+	for key, n := range counts {
+		managedHosts.WithLabelValues(key.cluster, key.kind, key.namespace).Set(float64(n))
+	}
+}
+
+// recordSynced updates the metrics after a successful sync.
+func recordSynced(cluster, kind, namespace, addr string, results []tinymon.Result) {
+	trackedHosts.add(addr, hostKey{cluster: cluster, kind: kind, namespace: namespace})
+	lastPush.WithLabelValues(cluster, addr).Set(float64(time.Now().Unix()))
+	for _, res := range results {
+		for _, status := range knownStatuses {
+			v := 0.0
+			if status == res.Status {
+				v = 1
+			}
+			checkStatus.WithLabelValues(cluster, addr, res.CheckType, status).Set(v)
+		}
+	}
+}
+
+// recordDeleted removes all series of a host.
+func recordDeleted(addr string) {
+	trackedHosts.remove(addr)
+	lastPush.DeletePartialMatch(prometheus.Labels{"address": addr})
+	checkStatus.DeletePartialMatch(prometheus.Labels{"address": addr})
+}
+
+// recordDeselected removes the series of a check no longer synced for the
+// host at addr.
+func recordDeselected(addr, checkType string) {
+	checkStatus.DeletePartialMatch(prometheus.Labels{"address": addr, "check": checkType})
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// namespaceAnnotations returns the tinymon.io annotations of a Namespace that
+// resources in it inherit. tinymon.io/name is excluded because a single display
+// name shared by every resource in the namespace makes no sense.
+func namespaceAnnotations(ns *corev1.Namespace) map[string]string {
+	result := make(map[string]string)
+	for k, v := range ns.Annotations {
+		if strings.HasPrefix(k, AnnotationPrefix) && k != AnnotationName {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// namespaceLabels returns the "tinymon.io/label-" labels of a Namespace.
+func namespaceLabels(ns *corev1.Namespace) map[string]string {
+	result := make(map[string]string)
+	for k, v := range ns.Labels {
+		if strings.HasPrefix(k, LabelPrefix) {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// mergeDefaults returns a new map with all defaults, overridden by the values
+// set on the resource itself.
+func mergeDefaults(defaults, own map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return own
+	}
+	result := make(map[string]string, len(defaults)+len(own))
+	for k, v := range defaults {
+		result[k] = v
+	}
+	for k, v := range own {
+		result[k] = v
+	}
+	return result
+}
+
+// namespaceDefaultsChanged only lets Namespace updates through that change
+// tinymon.io annotations or labels.
+var namespaceDefaultsChanged = predicate.Funcs{
+	CreateFunc:  func(event.CreateEvent) bool { return false },
+	DeleteFunc:  func(event.DeleteEvent) bool { return false },
+	GenericFunc: func(event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldNS, ok1 := e.ObjectOld.(*corev1.Namespace)
+		newNS, ok2 := e.ObjectNew.(*corev1.Namespace)
+		if !ok1 || !ok2 {
+			return false
+		}
+		return !equalMaps(namespaceAnnotations(oldNS), namespaceAnnotations(newNS)) ||
+			!equalMaps(namespaceLabels(oldNS), namespaceLabels(newNS))
+	},
+}
+
+// namespaceHandler returns an event handler that enqueues every object of the
+// given list type in a Namespace, so they pick up changed defaults.
+func namespaceHandler(c client.Reader, listType client.ObjectList) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+		return listRequests(ctx, c, listType, client.InNamespace(obj.GetName()))
+	})
+}
+
+func equalMaps(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	tinymonv1alpha1 "github.com/unclesamwk/tinymon-operator/api/v1alpha1"
 	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
 
 	corev1 "k8s.io/api/core/v1"
@@ -13,21 +14,25 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 type NodeReconciler struct {
 	client.Client
-	TinyMon   *tinymon.Client
-	Cluster   string
+	TinyMon tinymon.API
+	Options
 	Clientset kubernetes.Interface
 }
 
-func SetupNodeReconciler(mgr ctrl.Manager, tm *tinymon.Client, cluster string, cs kubernetes.Interface) error {
+func SetupNodeReconciler(mgr ctrl.Manager, tm tinymon.API, opts Options, cs kubernetes.Interface) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Node{}).
-		Complete(&NodeReconciler{Client: mgr.GetClient(), TinyMon: tm, Cluster: cluster, Clientset: cs})
+		For(&corev1.Node{}, builder.WithPredicates(ignoreStatusAnnotations)).
+		Watches(&tinymonv1alpha1.MonitoringPolicy{}, policyHandler(mgr.GetClient(), &corev1.NodeList{}), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WatchesRawSource(opts.rebalanceSource(mgr.GetClient(), &corev1.NodeList{})).
+		Complete(opts.reconciler("node", &NodeReconciler{Client: mgr.GetClient(), TinyMon: tm, Options: opts, Clientset: cs}))
 }
 
 func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -36,48 +41,87 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	var node corev1.Node
 	if err := r.Get(ctx, req.NamespacedName, &node); err != nil {
 		if errors.IsNotFound(err) {
-			log.Info("node deleted, removing from TinyMon")
+			policy := r.deletionPolicy(nil)
+			log.Info("node deleted, removing from TinyMon", "deletionPolicy", policy)
 			addr := resourceAddress(r.Cluster, "node", "", req.Name)
-			_ = r.TinyMon.DeleteHost(addr)
+			host := r.deletedHost(addr, req.Name, fmt.Sprintf("Kubernetes Node %s", req.Name), "nodes", "", "node")
+			if err := r.removeHost(r.TinyMon, policy, host); err != nil {
+				log.Error(err, "failed to remove host")
+				return ctrl.Result{}, err
+			}
+			r.reportRemoved(ctx, r.Client, nil, addr, policy)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
 
-	if !isEnabled(node.Annotations) {
-		addr := resourceAddress(r.Cluster, "node", "", node.Name)
-		_ = r.TinyMon.DeleteHost(addr)
-		return ctrl.Result{}, nil
+	annotations, labels, err := effectiveMetadata(ctx, r.Client, KindNode, &node)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
 	addr := resourceAddress(r.Cluster, "node", "", node.Name)
-	interval := checkInterval(node.Annotations, 60)
+	interval := checkInterval(annotations, 60)
 
+	data := r.templateData(ctx, r.Client, KindNode, &node, annotations, map[string]any{
+		"KubeletVersion": node.Status.NodeInfo.KubeletVersion,
+		"OSImage":        node.Status.NodeInfo.OSImage,
+		"Architecture":   node.Status.NodeInfo.Architecture,
+	})
 	host := tinymon.Host{
-		Name:        displayName(node.Annotations, node.Name),
+		Name:        r.hostName(ctx, annotations, data),
 		Address:     addr,
-		Description: fmt.Sprintf("Kubernetes Node %s", node.Name),
-		Topic:       defaultTopic(r.Cluster, "nodes", "", node.Annotations),
-		Labels:      buildLabels(r.Cluster, "node", node.Labels),
+		Description: r.hostDescription(ctx, annotations, data, fmt.Sprintf("Kubernetes Node %s", node.Name)),
+		Topic:       r.hostTopic(ctx, annotations, data, defaultTopic(r.Cluster, "nodes", "", annotations)),
+		Labels:      r.hostLabels("node", &node, data, labels),
 		Enabled:     1,
 	}
 
+	if !isEnabled(annotations) {
+		policy := r.deletionPolicy(annotations)
+		if err := r.removeHost(r.TinyMon, policy, host); err != nil {
+			log.Error(err, "failed to remove host")
+			return ctrl.Result{}, err
+		}
+		r.reportRemoved(ctx, r.Client, &node, addr, policy)
+		return ctrl.Result{}, nil
+	}
+
+	r.reportAnnotations(KindNode, &node, annotations)
+
+	now := time.Now()
+	maint := maintenanceState(annotations, now)
+	tm := maint.api(r.TinyMon)
+	if maint.active() {
+		log.Info("in maintenance, checks disabled", "until", maint.until)
+	}
+
 	log.Info("syncing node to TinyMon", "address", addr)
-	if err := r.TinyMon.UpsertHost(host); err != nil {
+	if err := tm.UpsertHost(host); err != nil {
 		log.Error(err, "failed to upsert host")
+		r.reportFailed(ctx, r.Client, KindNode, &node, addr, err)
 		return ctrl.Result{}, err
 	}
 
 	// Upsert checks: load, memory, disk
-	for _, checkType := range []string{"load", "memory"} {
+	checks := selectedChecks(annotations)
+	syncErr := r.removeDeselectedChecks(tm, addr, []string{"load", "memory", "disk"}, checks)
+	if syncErr != nil {
+		log.Error(syncErr, "failed to delete deselected checks")
+	}
+	for _, checkType := range []string{"load", "memory", "disk"} {
+		if !checks.enabled(checkType) {
+			continue
+		}
 		check := tinymon.Check{
 			HostAddress:     addr,
 			Type:            checkType,
-			IntervalSeconds: interval,
+			IntervalSeconds: r.Heartbeat.interval(interval),
 			Enabled:         1,
 		}
-		if err := r.TinyMon.UpsertCheck(check); err != nil {
+		if err := tm.UpsertCheck(check); err != nil {
 			log.Error(err, "failed to upsert check", "type", checkType)
+			syncErr = err
 		}
 	}
 
@@ -91,7 +135,7 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		allocMem := node.Status.Allocatable.Memory().Value()
 		if allocMem > 0 {
 			pct := float64(usedMem) / float64(allocMem) * 100
-			status := thresholdStatus(pct)
+			status := r.Hysteresis.thresholdStatus(annotations, addr, "memory", "memory", pct)
 			results = append(results, tinymon.Result{
 				HostAddress: addr,
 				CheckType:   "memory",
@@ -114,7 +158,7 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		allocCPU := node.Status.Allocatable.Cpu().MilliValue()
 		if allocCPU > 0 {
 			pct := float64(usedCPU) / float64(allocCPU) * 100
-			status := thresholdStatus(pct)
+			status := r.Hysteresis.thresholdStatus(annotations, addr, "load", "load", pct)
 			results = append(results, tinymon.Result{
 				HostAddress: addr,
 				CheckType:   "load",
@@ -132,15 +176,28 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		})
 	}
 
+	// Disk check via the kubelet's stats, read only if the check is enabled
+	if checks.enabled("disk") {
+		results = append(results, r.fetchDiskUsage(ctx, node.Name, addr, annotations))
+	}
 
-	if len(results) > 0 {
-		if err := r.TinyMon.PushBulk(results); err != nil {
+	results = r.Hysteresis.stabilize(annotations, r.renderMessages(ctx, annotations, data, checks.filterResults(results)))
+	if pending := r.Heartbeat.due(results, now, time.Duration(interval)*time.Second); len(pending) > 0 {
+		if err := tm.PushBulk(pending); err != nil {
 			log.Error(err, "failed to push bulk results")
+			r.reportFailed(ctx, r.Client, KindNode, &node, addr, err)
 			return ctrl.Result{}, err
 		}
+		r.Heartbeat.pushed(addr, maint, pending, now)
+	}
+
+	if syncErr != nil {
+		r.reportFailed(ctx, r.Client, KindNode, &node, addr, syncErr)
+	} else {
+		r.reportSynced(ctx, r.Client, KindNode, &node, addr, maint.results(results))
 	}
 
-	return ctrl.Result{RequeueAfter: time.Duration(interval) * time.Second}, nil
+	return ctrl.Result{RequeueAfter: maint.requeue(time.Duration(interval)*time.Second, now)}, nil
 }
 
 // kubeletStatsSummary represents the relevant parts of /stats/summary
@@ -154,7 +211,7 @@ type kubeletStatsSummary struct {
 	} `json:"node"`
 }
 
-func (r *NodeReconciler) fetchDiskUsage(ctx context.Context, nodeName, addr string) tinymon.Result {
+func (r *NodeReconciler) fetchDiskUsage(ctx context.Context, nodeName, addr string, annotations map[string]string) tinymon.Result {
 	raw, err := r.Clientset.CoreV1().RESTClient().
 		Get().
 		Resource("nodes").
@@ -193,7 +250,7 @@ func (r *NodeReconciler) fetchDiskUsage(ctx context.Context, nodeName, addr stri
 
 	usedBytes := *fs.CapacityBytes - *fs.AvailableBytes
 	pct := float64(usedBytes) / float64(*fs.CapacityBytes) * 100
-	status := thresholdStatus(pct)
+	status := r.Hysteresis.thresholdStatus(annotations, addr, "disk", "disk", pct)
 
 	return tinymon.Result{
 		HostAddress: addr,
@@ -233,16 +290,6 @@ func (r *NodeReconciler) fetchNodeMetrics(ctx context.Context, nodeName string)
 	return cpuQ.MilliValue(), memQ.Value(), nil
 }
 
-func thresholdStatus(pct float64) string {
-	if pct >= 90 {
-		return "critical"
-	}
-	if pct >= 80 {
-		return "warning"
-	}
-	return "ok"
-}
-
 func formatBytes(b int64) string {
 	const gi = 1024 * 1024 * 1024
 	const mi = 1024 * 1024
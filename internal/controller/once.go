@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	tinymonv1alpha1 "github.com/unclesamwk/tinymon-operator/api/v1alpha1"
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	k8upv1 "github.com/k8up-io/k8up/v2/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// RunOnce reconciles every object watched by the controllers once, using c
+// instead of a cache, and returns all errors. It is used by the sync,
+// export, diff and gc commands; pass a dry-run client and a tinymon.DryRun
+// to compute the desired state without changing anything.
+func RunOnce(ctx context.Context, c client.Client, tm tinymon.API, opts Options, cs kubernetes.Interface) error {
+	checkOpts := opts
+	checkOpts.WriteStatus = false
+
+	controllers := []struct {
+		name     string
+		listType client.ObjectList
+		r        reconcile.Reconciler
+	}{
+		{"node", &corev1.NodeList{}, &NodeReconciler{Client: c, TinyMon: tm, Options: opts, Clientset: cs}},
+		{"deployment", &appsv1.DeploymentList{}, &DeploymentReconciler{Client: c, APIReader: c, TinyMon: tm, Options: opts}},
+		{"ingress", &networkingv1.IngressList{}, &IngressReconciler{Client: c, APIReader: c, TinyMon: tm, Options: opts}},
+		{"pvc", &corev1.PersistentVolumeClaimList{}, &PVCReconciler{Client: c, APIReader: c, TinyMon: tm, Options: opts}},
+		{"backup", &k8upv1.ScheduleList{}, &BackupReconciler{Client: c, TinyMon: tm, Options: opts}},
+		{"tinymoncheck", &tinymonv1alpha1.TinyMonCheckList{}, &TinyMonCheckReconciler{Client: c, TinyMon: tm, Options: checkOpts}},
+	}
+
+	var errs []error
+	for _, ctl := range controllers {
+		list := ctl.listType.DeepCopyObject().(client.ObjectList)
+		if err := c.List(ctx, list); err != nil {
+			if meta.IsNoMatchError(err) {
+				// Optional CRDs, e.g. K8up, that aren't installed.
+				log.FromContext(ctx).V(1).Info("skipping controller, resource not installed", "controller", ctl.name)
+				continue
+			}
+			errs = append(errs, fmt.Errorf("list %s: %w", ctl.name, err))
+			continue
+		}
+		for _, req := range listItemRequests(list) {
+			if _, err := ctl.r.Reconcile(ctx, req); err != nil {
+				errs = append(errs, fmt.Errorf("%s %s: %w", ctl.name, req.NamespacedName, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
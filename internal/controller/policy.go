@@ -0,0 +1,369 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"text/template"
+	"time"
+
+	tinymonv1alpha1 "github.com/unclesamwk/tinymon-operator/api/v1alpha1"
+
+	k8upv1 "github.com/k8up-io/k8up/v2/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Kinds a MonitoringPolicy can select.
+const (
+	KindDeployment = "Deployment"
+	KindIngress    = "Ingress"
+	KindPVC        = "PersistentVolumeClaim"
+	KindNode       = "Node"
+	KindSchedule   = "Schedule"
+)
+
+// KindTinyMonCheck is the kind of hosts declared with a TinyMonCheck.
+const KindTinyMonCheck = "TinyMonCheck"
+
+// maxMatchedObjects caps the number of objects listed in a policy's status.
+const maxMatchedObjects = 100
+
+// policyListTypes maps every selectable kind to its list type.
+var policyListTypes = map[string]func() client.ObjectList{
+	KindDeployment: func() client.ObjectList { return &appsv1.DeploymentList{} },
+	KindIngress:    func() client.ObjectList { return &networkingv1.IngressList{} },
+	KindPVC:        func() client.ObjectList { return &corev1.PersistentVolumeClaimList{} },
+	KindNode:       func() client.ObjectList { return &corev1.NodeList{} },
+	KindSchedule:   func() client.ObjectList { return &k8upv1.ScheduleList{} },
+}
+
+// nameTemplateData is passed to a MonitoringPolicy's nameTemplate.
+type nameTemplateData struct {
+	Kind        string
+	Namespace   string
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// policyMatches reports whether a policy selects the given object.
+// nsLabels are the labels of the object's Namespace (nil if cluster-scoped).
+func policyMatches(policy *tinymonv1alpha1.MonitoringPolicy, kind string, obj client.Object, nsLabels map[string]string) (bool, error) {
+	kindMatch := false
+	for _, k := range policy.Spec.Kinds {
+		if k == kind {
+			kindMatch = true
+			break
+		}
+	}
+	if !kindMatch {
+		return false, nil
+	}
+	if policy.Spec.Selector != nil {
+		sel, err := metav1.LabelSelectorAsSelector(policy.Spec.Selector)
+		if err != nil {
+			return false, fmt.Errorf("invalid selector: %w", err)
+		}
+		if !sel.Matches(labels.Set(obj.GetLabels())) {
+			return false, nil
+		}
+	}
+	if policy.Spec.NamespaceSelector != nil && obj.GetNamespace() != "" {
+		sel, err := metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+		if err != nil {
+			return false, fmt.Errorf("invalid namespaceSelector: %w", err)
+		}
+		if !sel.Matches(labels.Set(nsLabels)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// policySettings converts a policy into the equivalent tinymon.io annotations
+// and "tinymon.io/label-" labels for the given object.
+func policySettings(policy *tinymonv1alpha1.MonitoringPolicy, kind string, obj client.Object) (map[string]string, map[string]string, error) {
+	annotations := make(map[string]string)
+	lbls := make(map[string]string)
+	spec := policy.Spec
+
+	if spec.Enabled != nil {
+		annotations[AnnotationEnabled] = strconv.FormatBool(*spec.Enabled)
+	}
+	if spec.NameTemplate != "" {
+		tmpl, err := template.New(policy.Name).Option("missingkey=zero").Parse(spec.NameTemplate)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid nameTemplate: %w", err)
+		}
+		var buf bytes.Buffer
+		data := nameTemplateData{
+			Kind:        kind,
+			Namespace:   obj.GetNamespace(),
+			Name:        obj.GetName(),
+			Labels:      obj.GetLabels(),
+			Annotations: obj.GetAnnotations(),
+		}
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, nil, fmt.Errorf("render nameTemplate: %w", err)
+		}
+		annotations[AnnotationName] = buf.String()
+	}
+	if spec.Topic != "" {
+		annotations[AnnotationTopic] = spec.Topic
+	}
+	if spec.CheckInterval > 0 {
+		annotations[AnnotationCheckInterval] = strconv.Itoa(int(spec.CheckInterval))
+	}
+	for k, v := range spec.Thresholds {
+		annotations[AnnotationThresholdPrefix+k] = v
+	}
+	for k, v := range spec.Labels {
+		lbls[LabelPrefix+k] = v
+	}
+	return annotations, lbls, nil
+}
+
+// matchingPolicies returns all policies selecting the object, ordered from
+// lowest to highest precedence (priority ascending, then name descending).
+func matchingPolicies(ctx context.Context, c client.Reader, kind string, obj client.Object, nsLabels map[string]string) ([]tinymonv1alpha1.MonitoringPolicy, error) {
+	var list tinymonv1alpha1.MonitoringPolicyList
+	if err := c.List(ctx, &list); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var matched []tinymonv1alpha1.MonitoringPolicy
+	for i := range list.Items {
+		ok, err := policyMatches(&list.Items[i], kind, obj, nsLabels)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "skipping invalid MonitoringPolicy", "policy", list.Items[i].Name)
+			continue
+		}
+		if ok {
+			matched = append(matched, list.Items[i])
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Spec.Priority != matched[j].Spec.Priority {
+			return matched[i].Spec.Priority < matched[j].Spec.Priority
+		}
+		return matched[i].Name > matched[j].Name
+	})
+	return matched, nil
+}
+
+// effectiveMetadata returns the annotations and labels the controllers work
+// with. Precedence from lowest to highest:
+//   - MonitoringPolicies (by priority)
+//   - tinymon.io annotations and labels on the object's Namespace
+//   - annotations and labels on the object itself
+//   - MonitoringPolicies with override: true (by priority)
+func effectiveMetadata(ctx context.Context, c client.Reader, kind string, obj client.Object) (map[string]string, map[string]string, error) {
+	annotations, lbls := obj.GetAnnotations(), obj.GetLabels()
+
+	var nsLabels map[string]string
+	if namespace := obj.GetNamespace(); namespace != "" {
+		var ns corev1.Namespace
+		if err := c.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+			if !errors.IsNotFound(err) {
+				return nil, nil, err
+			}
+		} else {
+			nsLabels = ns.Labels
+			annotations = mergeDefaults(namespaceAnnotations(&ns), annotations)
+			lbls = mergeDefaults(namespaceLabels(&ns), lbls)
+		}
+	}
+
+	policies, err := matchingPolicies(ctx, c, kind, obj, nsLabels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defaultAnnotations, defaultLabels := map[string]string{}, map[string]string{}
+	overrideAnnotations, overrideLabels := map[string]string{}, map[string]string{}
+	for i := range policies {
+		a, l, err := policySettings(&policies[i], kind, obj)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "skipping invalid MonitoringPolicy", "policy", policies[i].Name)
+			continue
+		}
+		if policies[i].Spec.Override {
+			overrideAnnotations = mergeDefaults(overrideAnnotations, a)
+			overrideLabels = mergeDefaults(overrideLabels, l)
+		} else {
+			defaultAnnotations = mergeDefaults(defaultAnnotations, a)
+			defaultLabels = mergeDefaults(defaultLabels, l)
+		}
+	}
+
+	annotations = mergeDefaults(mergeDefaults(defaultAnnotations, annotations), overrideAnnotations)
+	lbls = mergeDefaults(mergeDefaults(defaultLabels, lbls), overrideLabels)
+	return annotations, lbls, nil
+}
+
+// policyHandler returns an event handler that enqueues every object of the
+// given list type whenever a MonitoringPolicy changes. Objects are enqueued
+// regardless of the selectors, because objects that stopped matching need to
+// be reconciled as well.
+func policyHandler(c client.Reader, listType client.ObjectList) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, _ client.Object) []reconcile.Request {
+		return listRequests(ctx, c, listType)
+	})
+}
+
+// MonitoringPolicyReconciler maintains the status of MonitoringPolicies.
+// With Shards, each policy's status is written by one replica.
+type MonitoringPolicyReconciler struct {
+	client.Client
+	Options
+}
+
+func SetupMonitoringPolicyReconciler(mgr ctrl.Manager, opts Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&tinymonv1alpha1.MonitoringPolicy{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WatchesRawSource(opts.rebalanceSource(mgr.GetClient(), &tinymonv1alpha1.MonitoringPolicyList{})).
+		Complete(&MonitoringPolicyReconciler{Client: mgr.GetClient(), Options: opts})
+}
+
+func (r *MonitoringPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx).WithValues("monitoringpolicy", req.Name)
+
+	if owned, wait := r.Shards.Owns("policy-status/" + r.Cluster + "/" + req.Name); !owned {
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	var policy tinymonv1alpha1.MonitoringPolicy
+	if err := r.Get(ctx, req.NamespacedName, &policy); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	matched, matchErr := r.matchedObjects(ctx, &policy)
+	if matchErr == nil {
+		matchErr = validateThresholds(policy.Spec.Thresholds)
+	}
+
+	status := tinymonv1alpha1.MonitoringPolicyStatus{
+		ObservedGeneration: policy.Generation,
+		MatchedCount:       int32(len(matched)),
+		Conditions:         policy.Status.Conditions,
+	}
+	if len(matched) > maxMatchedObjects {
+		status.MatchedObjects = matched[:maxMatchedObjects]
+	} else {
+		status.MatchedObjects = matched
+	}
+
+	cond := metav1.Condition{
+		Type:               "Valid",
+		Status:             metav1.ConditionTrue,
+		Reason:             "Valid",
+		Message:            fmt.Sprintf("%d objects matched", len(matched)),
+		ObservedGeneration: policy.Generation,
+	}
+	if matchErr != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = "InvalidSpec"
+		cond.Message = matchErr.Error()
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+
+	policy.Status = status
+	if err := r.Status().Update(ctx, &policy); err != nil {
+		log.Error(err, "failed to update MonitoringPolicy status")
+		return ctrl.Result{}, err
+	}
+
+	// Label changes on selected objects don't trigger a reconcile, so the
+	// status is refreshed periodically.
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// validateThresholds returns the first invalid threshold of a policy.
+func validateThresholds(thresholds map[string]string) error {
+	types := make([]string, 0, len(thresholds))
+	for t := range thresholds {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		if _, _, err := parseThreshold(t, thresholds[t]); err != nil {
+			return fmt.Errorf("thresholds.%s: %w", t, err)
+		}
+	}
+	return nil
+}
+
+// matchedObjects lists all objects selected by the policy, sorted by kind,
+// namespace and name.
+func (r *MonitoringPolicyReconciler) matchedObjects(ctx context.Context, policy *tinymonv1alpha1.MonitoringPolicy) ([]tinymonv1alpha1.MatchedObject, error) {
+	nsLabels := map[string]map[string]string{}
+	var nsList corev1.NamespaceList
+	if err := r.List(ctx, &nsList); err != nil {
+		return nil, err
+	}
+	for _, ns := range nsList.Items {
+		nsLabels[ns.Name] = ns.Labels
+	}
+
+	var matched []tinymonv1alpha1.MatchedObject
+	for _, kind := range policy.Spec.Kinds {
+		newList, ok := policyListTypes[kind]
+		if !ok {
+			return matched, fmt.Errorf("unsupported kind %q", kind)
+		}
+		list := r.listType(r.Scheme(), newList())
+		if err := r.List(ctx, list); err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return matched, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return matched, err
+		}
+		for _, item := range items {
+			obj, ok := item.(client.Object)
+			if !ok {
+				continue
+			}
+			ok, err := policyMatches(policy, kind, obj, nsLabels[obj.GetNamespace()])
+			if err != nil {
+				return matched, err
+			}
+			if ok {
+				matched = append(matched, tinymonv1alpha1.MatchedObject{Kind: kind, Namespace: obj.GetNamespace(), Name: obj.GetName()})
+			}
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return matched, nil
+}
@@ -2,53 +2,77 @@ package controller
 
 import (
 	"context"
-	"time"
 	"fmt"
+	"time"
 
+	tinymonv1alpha1 "github.com/unclesamwk/tinymon-operator/api/v1alpha1"
 	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 type PVCReconciler struct {
 	client.Client
-	TinyMon *tinymon.Client
-	Cluster string
+	// APIReader reads objects watched metadata-only in full.
+	APIReader client.Reader
+	TinyMon   tinymon.API
+	Options
 }
 
-func SetupPVCReconciler(mgr ctrl.Manager, tm *tinymon.Client, cluster string) error {
+func SetupPVCReconciler(mgr ctrl.Manager, tm tinymon.API, opts Options) error {
+	list := opts.listType(mgr.GetScheme(), &corev1.PersistentVolumeClaimList{})
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.PersistentVolumeClaim{}).
-		Complete(&PVCReconciler{Client: mgr.GetClient(), TinyMon: tm, Cluster: cluster})
+		For(&corev1.PersistentVolumeClaim{}, opts.forOptions(&corev1.PersistentVolumeClaim{})...).
+		Watches(&corev1.Namespace{}, namespaceHandler(mgr.GetClient(), list), builder.WithPredicates(namespaceDefaultsChanged)).
+		Watches(&tinymonv1alpha1.MonitoringPolicy{}, policyHandler(mgr.GetClient(), list), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WatchesRawSource(opts.rebalanceSource(mgr.GetClient(), list)).
+		Complete(opts.reconciler("pvc", &PVCReconciler{Client: mgr.GetClient(), APIReader: mgr.GetAPIReader(), TinyMon: tm, Options: opts}))
 }
 
 func (r *PVCReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx).WithValues("pvc", req.NamespacedName)
 
 	var pvc corev1.PersistentVolumeClaim
-	if err := r.Get(ctx, req.NamespacedName, &pvc); err != nil {
+	if err := r.getObject(ctx, r.Client, r.APIReader, KindPVC, req.NamespacedName, &pvc); err != nil {
 		if errors.IsNotFound(err) {
-			log.Info("PVC deleted, removing from TinyMon")
+			policy := r.deletionPolicy(nil)
+			log.Info("PVC deleted, removing from TinyMon", "deletionPolicy", policy)
 			addr := resourceAddress(r.Cluster, "pvc", req.Namespace, req.Name)
-			_ = r.TinyMon.DeleteHost(addr)
+			api, err := r.tinymon(ctx, r.Client, r.TinyMon, req.Namespace)
+			if err != nil {
+				log.Error(err, "failed to resolve TinyMon credentials")
+				return ctrl.Result{}, err
+			}
+			host := r.deletedHost(addr, req.Name, fmt.Sprintf("PVC %s/%s", req.Namespace, req.Name), "storage", req.Namespace, "storage")
+			if err := r.removeHost(api, policy, host); err != nil {
+				log.Error(err, "failed to remove host")
+				return ctrl.Result{}, err
+			}
+			r.reportRemoved(ctx, r.Client, nil, addr, policy)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
 
-	if !isEnabled(pvc.Annotations) {
-		addr := resourceAddress(r.Cluster, "pvc", pvc.Namespace, pvc.Name)
-		_ = r.TinyMon.DeleteHost(addr)
-		return ctrl.Result{}, nil
+	annotations, labels, err := effectiveMetadata(ctx, r.Client, KindPVC, &pvc)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
 	addr := resourceAddress(r.Cluster, "pvc", pvc.Namespace, pvc.Name)
-	interval := checkInterval(pvc.Annotations, 60)
-	t := defaultTopic(r.Cluster, "storage", pvc.Namespace, pvc.Annotations)
+	api, err := r.tinymon(ctx, r.Client, r.TinyMon, pvc.Namespace)
+	if err != nil {
+		log.Error(err, "failed to resolve TinyMon credentials")
+		r.reportFailed(ctx, r.Client, KindPVC, &pvc, addr, err)
+		return ctrl.Result{}, err
+	}
+	interval := checkInterval(annotations, 60)
 
 	sizeStr := ""
 	var sizeGB float64
@@ -62,46 +86,88 @@ func (r *PVCReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		storageClass = *pvc.Spec.StorageClassName
 	}
 
+	data := r.templateData(ctx, r.Client, KindPVC, &pvc, annotations, map[string]any{
+		"Size":         sizeStr,
+		"StorageClass": storageClass,
+		"Phase":        string(pvc.Status.Phase),
+	})
 	host := tinymon.Host{
-		Name:        displayName(pvc.Annotations, pvc.Name),
+		Name:        r.hostName(ctx, annotations, data),
 		Address:     addr,
-		Description: fmt.Sprintf("PVC %s/%s (%s, %s)", pvc.Namespace, pvc.Name, sizeStr, storageClass),
-		Topic:       t,
-		Labels:      buildLabels(r.Cluster, "storage", pvc.Labels),
+		Description: r.hostDescription(ctx, annotations, data, fmt.Sprintf("PVC %s/%s (%s, %s)", pvc.Namespace, pvc.Name, sizeStr, storageClass)),
+		Topic:       r.hostTopic(ctx, annotations, data, defaultTopic(r.Cluster, "storage", pvc.Namespace, annotations)),
+		Labels:      r.hostLabels("storage", &pvc, data, labels),
 		Enabled:     1,
 	}
 
+	if !isEnabled(annotations) {
+		policy := r.deletionPolicy(annotations)
+		if err := r.removeHost(api, policy, host); err != nil {
+			log.Error(err, "failed to remove host")
+			return ctrl.Result{}, err
+		}
+		r.reportRemoved(ctx, r.Client, &pvc, addr, policy)
+		return ctrl.Result{}, nil
+	}
+
+	r.reportAnnotations(KindPVC, &pvc, annotations)
+
+	now := time.Now()
+	maint := maintenanceState(annotations, now)
+	tm := maint.api(api)
+	if maint.active() {
+		log.Info("in maintenance, checks disabled", "until", maint.until)
+	}
+
 	log.Info("syncing PVC to TinyMon", "address", addr)
-	if err := r.TinyMon.UpsertHost(host); err != nil {
+	if err := tm.UpsertHost(host); err != nil {
 		log.Error(err, "failed to upsert host")
+		r.reportFailed(ctx, r.Client, KindPVC, &pvc, addr, err)
 		return ctrl.Result{}, err
 	}
 
-	check := tinymon.Check{
-		HostAddress:     addr,
-		Type:            "disk",
-		IntervalSeconds: interval,
-		Enabled:         1,
-	}
-	if err := r.TinyMon.UpsertCheck(check); err != nil {
-		log.Error(err, "failed to upsert check")
+	checks := selectedChecks(annotations)
+	if err := r.removeDeselectedChecks(tm, addr, []string{"disk"}, checks); err != nil {
+		log.Error(err, "failed to delete deselected checks")
+		r.reportFailed(ctx, r.Client, KindPVC, &pvc, addr, err)
 		return ctrl.Result{}, err
 	}
 
-	status, msg := pvcStatus(&pvc, sizeStr, storageClass)
-	results := []tinymon.Result{{
-		HostAddress: addr,
-		CheckType:   "disk",
-		Status:      status,
-		Value:       sizeGB,
-		Message:     msg,
-	}}
-	if err := r.TinyMon.PushBulk(results); err != nil {
-		log.Error(err, "failed to push bulk results")
-		return ctrl.Result{}, err
+	var results []tinymon.Result
+	if checks.enabled("disk") {
+		check := tinymon.Check{
+			HostAddress:     addr,
+			Type:            "disk",
+			IntervalSeconds: r.Heartbeat.interval(interval),
+			Enabled:         1,
+		}
+		if err := tm.UpsertCheck(check); err != nil {
+			log.Error(err, "failed to upsert check")
+			r.reportFailed(ctx, r.Client, KindPVC, &pvc, addr, err)
+			return ctrl.Result{}, err
+		}
+
+		status, msg := pvcStatus(&pvc, sizeStr, storageClass)
+		results = r.Hysteresis.stabilize(annotations, r.renderMessages(ctx, annotations, data, []tinymon.Result{{
+			HostAddress: addr,
+			CheckType:   "disk",
+			Status:      status,
+			Value:       sizeGB,
+			Message:     msg,
+		}}))
+		if pending := r.Heartbeat.due(results, now, time.Duration(interval)*time.Second); len(pending) > 0 {
+			if err := tm.PushBulk(pending); err != nil {
+				log.Error(err, "failed to push bulk results")
+				r.reportFailed(ctx, r.Client, KindPVC, &pvc, addr, err)
+				return ctrl.Result{}, err
+			}
+			r.Heartbeat.pushed(addr, maint, pending, now)
+		}
 	}
 
-	return ctrl.Result{RequeueAfter: time.Duration(interval) * time.Second}, nil
+	r.reportSynced(ctx, r.Client, KindPVC, &pvc, addr, maint.results(results))
+
+	return ctrl.Result{RequeueAfter: maint.requeue(time.Duration(interval)*time.Second, now)}, nil
 }
 
 func pvcStatus(pvc *corev1.PersistentVolumeClaim, size, storageClass string) (string, string) {
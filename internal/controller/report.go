@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/debug"
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// reportSynced records a successful sync of obj to the host at addr, along
+// with the results pushed for it (nil for pull-only hosts).
+func (o Options) reportSynced(ctx context.Context, c client.Client, kind string, obj client.Object, addr string, results []tinymon.Result) {
+	setReconciledAddress(ctx, addr)
+	o.Disabled.synced(addr)
+	o.Debug.Synced(addr, debugSource(kind, obj))
+	o.Events.Results(obj, results)
+	if o.DryRun {
+		o.Events.DryRun(obj, addr)
+		return
+	}
+	recordSynced(o.Cluster, kind, obj.GetNamespace(), addr, results)
+	o.Events.Synced(obj, addr)
+	o.writeStatus(ctx, c, obj, addr, worstStatus(results), nil)
+}
+
+// reportFailed records a failed TinyMon call while syncing obj.
+func (o Options) reportFailed(ctx context.Context, c client.Client, kind string, obj client.Object, addr string, err error) {
+	setReconciledAddress(ctx, addr)
+	o.Debug.Failed(addr, debugSource(kind, obj), err)
+	syncErrors.WithLabelValues(kind).Inc()
+	o.Events.SyncFailed(obj, err)
+	o.writeStatus(ctx, c, obj, addr, obj.GetAnnotations()[AnnotationLastStatus], err)
+}
+
+// reportDeleted records that the host at addr was deleted because
+// monitoring was turned off for obj, or obj was deleted (obj == nil).
+func (o Options) reportDeleted(ctx context.Context, c client.Client, obj client.Object, addr string) {
+	o.reportRemoved(ctx, c, obj, addr, DeletionPolicyDelete)
+}
+
+// reportRemoved records that the host at addr is no longer synced and was
+// handled according to policy.
+func (o Options) reportRemoved(ctx context.Context, c client.Client, obj client.Object, addr string, policy DeletionPolicy) {
+	recordDeleted(addr)
+	o.Hysteresis.forget(addr)
+	o.Heartbeat.forget(addr)
+	o.RemovedChecks.forget(addr)
+	o.Debug.Deleted(addr)
+	if o.DryRun {
+		// Nothing was removed, only the state is dropped.
+		obj = nil
+	}
+	o.Events.HostRemoved(obj, addr, policy)
+	if obj != nil {
+		o.writeStatus(ctx, c, obj, "", "", nil)
+	}
+}
+
+// reportAnnotations records every tinymon.io annotation of obj that is
+// ignored because its value is invalid.
+func (o Options) reportAnnotations(kind string, obj client.Object, annotations map[string]string) {
+	errs := validateAnnotations(annotations)
+	for key := range errs {
+		annotationErrors.WithLabelValues(kind, key).Inc()
+	}
+	o.Events.InvalidAnnotations(obj, annotations, errs)
+}
+
+func debugSource(kind string, obj client.Object) debug.Source {
+	return debug.Source{Kind: kind, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+}
+
+// reconciledAddressKey is the context key of the address a reconcile synced.
+type reconciledAddressKey struct{}
+
+// setReconciledAddress tells trackSchedule which host the reconcile in ctx
+// synced.
+func setReconciledAddress(ctx context.Context, addr string) {
+	if p, ok := ctx.Value(reconciledAddressKey{}).(*string); ok {
+		*p = addr
+	}
+}
+
+// trackSchedule wraps r to record when the host it synced is reconciled
+// next.
+func (o Options) trackSchedule(r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		var addr string
+		res, err := r.Reconcile(context.WithValue(ctx, reconciledAddressKey{}, &addr), req)
+		if addr != "" {
+			var next time.Time
+			if err == nil && res.RequeueAfter > 0 {
+				next = time.Now().Add(res.RequeueAfter)
+			}
+			o.Debug.Scheduled(addr, next)
+		}
+		return res, err
+	})
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// shardKey is the key an object of this cluster is assigned to a replica by.
+func (o Options) shardKey(req reconcile.Request) string {
+	return o.Cluster + "/" + req.NamespacedName.String()
+}
+
+// sharded wraps r so it only reconciles the objects of this replica's shard.
+// When an object moves to another replica, the state kept for its host is
+// dropped, so it starts afresh if the object comes back.
+func (o Options) sharded(r reconcile.Reconciler) reconcile.Reconciler {
+	if o.Shards == nil {
+		return r
+	}
+	var mu sync.Mutex
+	addrs := make(map[reconcile.Request]string)
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		owned, wait := o.Shards.Owns(o.shardKey(req))
+		if !owned {
+			mu.Lock()
+			addr, ok := addrs[req]
+			delete(addrs, req)
+			mu.Unlock()
+			if ok {
+				o.releaseHost(addr)
+			}
+			return reconcile.Result{RequeueAfter: wait}, nil
+		}
+
+		var addr string
+		res, err := r.Reconcile(context.WithValue(ctx, reconciledAddressKey{}, &addr), req)
+		mu.Lock()
+		switch {
+		case addr != "":
+			addrs[req] = addr
+		case err == nil:
+			// The host was removed.
+			delete(addrs, req)
+		}
+		mu.Unlock()
+		if addr != "" {
+			setReconciledAddress(ctx, addr)
+		}
+		return res, err
+	})
+}
+
+// releaseHost drops the state kept for the host at addr, which is synced by
+// another replica now.
+func (o Options) releaseHost(addr string) {
+	recordDeleted(addr)
+	o.Hysteresis.forget(addr)
+	o.Heartbeat.forget(addr)
+	o.RemovedChecks.forget(addr)
+	o.Disabled.forget(addr)
+	o.Debug.Deleted(addr)
+}
+
+// rebalanceSource enqueues every object of the given list type when the
+// replicas sharing the objects change, so each replica picks up the objects
+// it gained and lets go of the ones it lost.
+func (o Options) rebalanceSource(c client.Reader, listType client.ObjectList) source.Source {
+	return source.Func(func(ctx context.Context, queue workqueue.TypedRateLimitingInterface[reconcile.Request]) error {
+		if o.Shards == nil {
+			return nil
+		}
+		changes, cancel := o.Shards.Subscribe()
+		go func() {
+			defer cancel()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-changes:
+					for _, req := range listRequests(ctx, c, listType) {
+						queue.Add(req)
+					}
+				}
+			}
+		}()
+		return nil
+	})
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// Status annotations written back onto monitored objects.
+const (
+	AnnotationAddress    = "tinymon.io/address"
+	AnnotationLastSync   = "tinymon.io/last-sync"
+	AnnotationLastStatus = "tinymon.io/last-status"
+	AnnotationSyncError  = "tinymon.io/sync-error"
+)
+
+var statusAnnotations = []string{AnnotationAddress, AnnotationLastSync, AnnotationLastStatus, AnnotationSyncError}
+
+// lastSyncRefresh is how old tinymon.io/last-sync may get before it is
+// rewritten although nothing else changed. Rewriting it on every reconcile
+// would cause an API write per object and interval.
+const lastSyncRefresh = 15 * time.Minute
+
+// statusPriority orders statuses from best to worst.
+var statusPriority = map[string]int{"ok": 0, "unknown": 1, "warning": 2, "critical": 3}
+
+// worstStatus returns the worst status of the given results, or "" if there
+// are none.
+func worstStatus(results []tinymon.Result) string {
+	worst := ""
+	for _, res := range results {
+		if worst == "" || statusPriority[res.Status] > statusPriority[worst] {
+			worst = res.Status
+		}
+	}
+	return worst
+}
+
+// writeStatus patches the status annotations onto obj. Nothing is written if
+// the values didn't change, apart from refreshing an outdated last-sync.
+// An empty addr removes all status annotations.
+func (o Options) writeStatus(ctx context.Context, c client.Client, obj client.Object, addr, status string, syncErr error) {
+	if !o.WriteStatus {
+		return
+	}
+
+	current := obj.GetAnnotations()
+	desired := make(map[string]string)
+	if addr != "" {
+		desired[AnnotationAddress] = addr
+		if status != "" {
+			desired[AnnotationLastStatus] = status
+		}
+		if syncErr != nil {
+			desired[AnnotationSyncError] = syncErr.Error()
+			if v, ok := current[AnnotationLastSync]; ok {
+				desired[AnnotationLastSync] = v
+			}
+		} else {
+			desired[AnnotationLastSync] = current[AnnotationLastSync]
+			last, err := time.Parse(time.RFC3339, current[AnnotationLastSync])
+			if err != nil || time.Since(last) > lastSyncRefresh || !statusUnchanged(current, desired) {
+				desired[AnnotationLastSync] = time.Now().UTC().Format(time.RFC3339)
+			}
+		}
+	}
+
+	changed := false
+	for _, key := range statusAnnotations {
+		v, ok := desired[key]
+		cv, cok := current[key]
+		if ok != cok || v != cv {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return
+	}
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	annotations := make(map[string]string, len(current)+len(desired))
+	for k, v := range current {
+		annotations[k] = v
+	}
+	for _, key := range statusAnnotations {
+		if v, ok := desired[key]; ok {
+			annotations[key] = v
+		} else {
+			delete(annotations, key)
+		}
+	}
+	obj.SetAnnotations(annotations)
+	if err := c.Patch(ctx, obj, patch); err != nil {
+		log.FromContext(ctx).Error(err, "failed to write status annotations")
+	}
+}
+
+// statusUnchanged reports whether address, status and error are the same in
+// both annotation maps.
+func statusUnchanged(current, desired map[string]string) bool {
+	for _, key := range []string{AnnotationAddress, AnnotationLastStatus, AnnotationSyncError} {
+		if current[key] != desired[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// ignoreStatusAnnotations filters out updates that only touch the status
+// annotations written by the operator itself, so writing them doesn't
+// trigger another reconcile.
+var ignoreStatusAnnotations = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return !equality.Semantic.DeepEqual(withoutStatus(e.ObjectOld), withoutStatus(e.ObjectNew))
+	},
+}
+
+// ignoreStatusAnnotationWrites is ignoreStatusAnnotations for metadata-only
+// watches. Their objects don't show changes outside the metadata, e.g. of the
+// status, so any other update with a new resource version passes as well.
+var ignoreStatusAnnotationWrites = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if !equality.Semantic.DeepEqual(withoutStatus(e.ObjectOld), withoutStatus(e.ObjectNew)) {
+			return true
+		}
+		old, cur := e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations()
+		for _, key := range statusAnnotations {
+			if old[key] != cur[key] {
+				return false
+			}
+		}
+		return e.ObjectOld.GetResourceVersion() != e.ObjectNew.GetResourceVersion()
+	},
+}
+
+// withoutStatus returns a copy of obj without status annotations and the
+// metadata that changes on every write.
+func withoutStatus(obj client.Object) client.Object {
+	cp := obj.DeepCopyObject().(client.Object)
+	annotations := cp.GetAnnotations()
+	for _, key := range statusAnnotations {
+		delete(annotations, key)
+	}
+	cp.SetAnnotations(annotations)
+	cp.SetResourceVersion("")
+	cp.SetManagedFields(nil)
+	return cp
+}
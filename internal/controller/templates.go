@@ -0,0 +1,320 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Template annotations. They override the templates configured for the
+// object's kind.
+const (
+	AnnotationNameTemplate        = "tinymon.io/name-template"
+	AnnotationDescriptionTemplate = "tinymon.io/description-template"
+	AnnotationTopicTemplate       = "tinymon.io/topic-template"
+	AnnotationMessageTemplate     = "tinymon.io/message-template"
+)
+
+// TemplateConfig holds the templates of a kind. Empty templates keep the
+// built-in text.
+type TemplateConfig struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Topic       string `json:"topic,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+// TemplateData is passed to name, description and message templates.
+type TemplateData struct {
+	Cluster   string
+	Kind      string
+	Namespace string
+	Name      string
+	// Labels are the labels of the object.
+	Labels map[string]string
+	// NamespaceLabels are the labels of the object's Namespace.
+	NamespaceLabels map[string]string
+	// Annotations are the effective annotations, including the ones
+	// inherited from the Namespace and MonitoringPolicies.
+	Annotations map[string]string
+	// Values are computed per kind, e.g. the replica counts of a Deployment.
+	Values map[string]any
+
+	// Check, Status, Value and Message describe the result, only set for
+	// message templates. Message is the built-in message.
+	Check   string
+	Status  string
+	Value   float64
+	Message string
+}
+
+// templateFuncs are available in all templates.
+var templateFuncs = template.FuncMap{
+	// default returns def if v is empty, e.g. {{ default "none" .Labels.team }}.
+	"default": func(def string, v any) any {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Funcs(templateFuncs).Parse(text)
+}
+
+// rangeFields are the fields annotation templates may range over, maps of
+// the object's metadata whose size the API server limits.
+var rangeFields = map[string]bool{"Labels": true, "NamespaceLabels": true, "Annotations": true}
+
+// parseAnnotationTemplate parses a template set by annotation. Anyone who
+// can annotate an object or Namespace can set one, so templates that could
+// run for long are rejected: range over anything but the metadata maps,
+// nested ranges, and template definitions and calls, which can recurse.
+func parseAnnotationTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := parseTemplate(name, text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return nil, fmt.Errorf("template definitions are not allowed")
+	}
+	if err := checkNodes(tmpl.Tree.Root, false); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// checkNodes checks the nodes of an annotation template, inRange is set
+// inside a range.
+func checkNodes(node parse.Node, inRange bool) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkNodes(child, inRange); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode, inRange)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode, inRange)
+	case *parse.RangeNode:
+		if inRange {
+			return fmt.Errorf("nested range is not allowed")
+		}
+		if !rangesOverMetadata(n.Pipe) {
+			return fmt.Errorf("range is only allowed over .Labels, .NamespaceLabels and .Annotations")
+		}
+		return checkBranch(&n.BranchNode, true)
+	case *parse.TemplateNode:
+		return fmt.Errorf("template calls are not allowed")
+	}
+	return nil
+}
+
+func checkBranch(n *parse.BranchNode, inRange bool) error {
+	if err := checkNodes(n.List, inRange); err != nil {
+		return err
+	}
+	return checkNodes(n.ElseList, inRange)
+}
+
+// rangesOverMetadata reports whether pipe is a single field in rangeFields,
+// e.g. {{ range $k, $v := .Labels }}.
+func rangesOverMetadata(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	field, ok := pipe.Cmds[0].Args[0].(*parse.FieldNode)
+	return ok && len(field.Ident) == 1 && rangeFields[field.Ident[0]]
+}
+
+// maxTemplateOutput bounds the text a template renders.
+const maxTemplateOutput = 4096
+
+var errTemplateOutput = fmt.Errorf("output exceeds %d bytes", maxTemplateOutput)
+
+// limitedBuffer is a buffer that fails once it would exceed
+// maxTemplateOutput, which stops the template.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > maxTemplateOutput {
+		return 0, errTemplateOutput
+	}
+	return b.Buffer.Write(p)
+}
+
+// kindTemplates are the parsed templates of a kind, nil if not configured.
+type kindTemplates struct {
+	name, description, topic, message *template.Template
+}
+
+// Templates holds the configured templates per kind. A nil Templates uses
+// the built-in texts unless an annotation sets a template.
+type Templates struct {
+	kinds map[string]kindTemplates
+}
+
+// NewTemplates parses the templates configured per kind.
+func NewTemplates(cfg map[string]TemplateConfig) (*Templates, error) {
+	t := &Templates{kinds: make(map[string]kindTemplates, len(cfg))}
+	for kind, c := range cfg {
+		if _, ok := policyListTypes[kind]; !ok {
+			return nil, fmt.Errorf("templates: unknown kind %q", kind)
+		}
+		var kt kindTemplates
+		var err error
+		for _, f := range []struct {
+			field string
+			text  string
+			tmpl  **template.Template
+		}{
+			{"name", c.Name, &kt.name},
+			{"description", c.Description, &kt.description},
+			{"topic", c.Topic, &kt.topic},
+			{"message", c.Message, &kt.message},
+		} {
+			if f.text == "" {
+				continue
+			}
+			if *f.tmpl, err = parseTemplate(kind+"."+f.field, f.text); err != nil {
+				return nil, fmt.Errorf("templates: %s.%s: %w", kind, f.field, err)
+			}
+		}
+		t.kinds[kind] = kt
+	}
+	return t, nil
+}
+
+// lookup returns the template of kind set by annotation, else the
+// configured one, or nil.
+func (t *Templates) lookup(kind, annotation string, annotations map[string]string) *template.Template {
+	if text := annotations[annotation]; text != "" {
+		tmpl, err := parseAnnotationTemplate(annotation, text)
+		if err != nil {
+			// Reported by validateAnnotations.
+			return nil
+		}
+		return tmpl
+	}
+	if t == nil {
+		return nil
+	}
+	kt := t.kinds[kind]
+	switch annotation {
+	case AnnotationNameTemplate:
+		return kt.name
+	case AnnotationDescriptionTemplate:
+		return kt.description
+	case AnnotationTopicTemplate:
+		return kt.topic
+	default:
+		return kt.message
+	}
+}
+
+// templateData returns the data for the templates of obj.
+func (o Options) templateData(ctx context.Context, c client.Reader, kind string, obj client.Object, annotations map[string]string, values map[string]any) TemplateData {
+	var nsLabels map[string]string
+	if namespace := obj.GetNamespace(); namespace != "" {
+		var ns corev1.Namespace
+		if err := c.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err == nil {
+			nsLabels = ns.Labels
+		}
+	}
+	return TemplateData{
+		Cluster:         o.Cluster,
+		Kind:            kind,
+		Namespace:       obj.GetNamespace(),
+		Name:            obj.GetName(),
+		Labels:          obj.GetLabels(),
+		NamespaceLabels: nsLabels,
+		Annotations:     annotations,
+		Values:          values,
+	}
+}
+
+// render executes the template set by annotation or configured for the kind
+// and returns fallback if there is none or it fails.
+func (o Options) render(ctx context.Context, annotation string, annotations map[string]string, data TemplateData, fallback string) string {
+	return execute(ctx, o.Templates.lookup(data.Kind, annotation, annotations), data, fallback)
+}
+
+func execute(ctx context.Context, tmpl *template.Template, data TemplateData, fallback string) string {
+	if tmpl == nil {
+		return fallback
+	}
+	var buf limitedBuffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.FromContext(ctx).Info("template failed, using the built-in text", "template", tmpl.Name(), "error", err.Error())
+		return fallback
+	}
+	return buf.String()
+}
+
+// hostName returns tinymon.io/name, else the rendered name template, else the
+// object name.
+func (o Options) hostName(ctx context.Context, annotations map[string]string, data TemplateData) string {
+	if name := annotations[AnnotationName]; name != "" {
+		return name
+	}
+	return o.render(ctx, AnnotationNameTemplate, annotations, data, data.Name)
+}
+
+// hostDescription returns the rendered description template, or fallback.
+func (o Options) hostDescription(ctx context.Context, annotations map[string]string, data TemplateData, fallback string) string {
+	return o.render(ctx, AnnotationDescriptionTemplate, annotations, data, fallback)
+}
+
+// hostTopic returns tinymon.io/topic, else the rendered topic template, else
+// fallback. Empty segments of a rendered topic, e.g. of a missing label, are
+// dropped, and fallback is used if nothing is left.
+func (o Options) hostTopic(ctx context.Context, annotations map[string]string, data TemplateData, fallback string) string {
+	if topic := annotations[AnnotationTopic]; topic != "" {
+		return topic
+	}
+	var segments []string
+	for _, s := range strings.Split(o.render(ctx, AnnotationTopicTemplate, annotations, data, fallback), "/") {
+		if s = strings.TrimSpace(s); s != "" {
+			segments = append(segments, s)
+		}
+	}
+	if len(segments) == 0 {
+		return fallback
+	}
+	return strings.Join(segments, "/")
+}
+
+// renderMessages replaces the messages of results with the rendered message
+// template.
+func (o Options) renderMessages(ctx context.Context, annotations map[string]string, data TemplateData, results []tinymon.Result) []tinymon.Result {
+	tmpl := o.Templates.lookup(data.Kind, AnnotationMessageTemplate, annotations)
+	if tmpl == nil {
+		return results
+	}
+	out := make([]tinymon.Result, len(results))
+	for i, res := range results {
+		d := data
+		d.Check, d.Status, d.Value, d.Message = res.CheckType, res.Status, res.Value, res.Message
+		res.Message = execute(ctx, tmpl, d, res.Message)
+		out[i] = res
+	}
+	return out
+}
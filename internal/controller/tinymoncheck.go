@@ -0,0 +1,323 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	tinymonv1alpha1 "github.com/unclesamwk/tinymon-operator/api/v1alpha1"
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// FinalizerTinyMonCheck makes sure the TinyMon host of a TinyMonCheck is
+// removed before the object is gone, since a custom address can't be derived
+// from the object's name afterwards.
+const FinalizerTinyMonCheck = "tinymon.io/cleanup"
+
+// Condition types set on TinyMonCheck objects.
+const (
+	ConditionValid  = "Valid"
+	ConditionSynced = "Synced"
+)
+
+var checkTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+type TinyMonCheckReconciler struct {
+	client.Client
+	TinyMon tinymon.API
+	Options
+}
+
+func SetupTinyMonCheckReconciler(mgr ctrl.Manager, tm tinymon.API, opts Options) error {
+	// The sync state is reported in .status instead of annotations.
+	opts.WriteStatus = false
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&tinymonv1alpha1.TinyMonCheck{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WatchesRawSource(opts.rebalanceSource(mgr.GetClient(), &tinymonv1alpha1.TinyMonCheckList{})).
+		Complete(opts.reconciler("tinymoncheck", &TinyMonCheckReconciler{Client: mgr.GetClient(), TinyMon: tm, Options: opts}))
+}
+
+func (r *TinyMonCheckReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx).WithValues("tinymoncheck", req.NamespacedName)
+
+	var tmc tinymonv1alpha1.TinyMonCheck
+	if err := r.Get(ctx, req.NamespacedName, &tmc); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	api, err := r.tinymon(ctx, r.Client, r.TinyMon, tmc.Namespace)
+	if err != nil {
+		log.Error(err, "failed to resolve TinyMon credentials")
+		if !tmc.DeletionTimestamp.IsZero() {
+			return ctrl.Result{}, err
+		}
+		return r.syncFailed(ctx, &tmc, err)
+	}
+
+	if !tmc.DeletionTimestamp.IsZero() {
+		if tmc.Status.Address != "" && !r.ownAddress(&tmc, tmc.Status.Address) {
+			log.Info("not removing host outside the TinyMonCheck's namespace", "address", tmc.Status.Address)
+		} else if tmc.Status.Address != "" {
+			policy := r.deletionPolicy(tmc.Annotations)
+			log.Info("TinyMonCheck deleted, removing from TinyMon", "address", tmc.Status.Address, "deletionPolicy", policy)
+			host := r.host(ctx, &tmc)
+			host.Address = tmc.Status.Address
+			if err := r.removeHost(api, policy, host); err != nil {
+				log.Error(err, "failed to remove host")
+				return ctrl.Result{}, err
+			}
+			r.reportRemoved(ctx, r.Client, nil, tmc.Status.Address, policy)
+		}
+		patch := client.MergeFrom(tmc.DeepCopy())
+		controllerutil.RemoveFinalizer(&tmc, FinalizerTinyMonCheck)
+		return ctrl.Result{}, r.Patch(ctx, &tmc, patch)
+	}
+
+	patch := client.MergeFrom(tmc.DeepCopy())
+	if controllerutil.AddFinalizer(&tmc, FinalizerTinyMonCheck) {
+		if err := r.Patch(ctx, &tmc, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	checks, err := r.desiredChecks(&tmc)
+	if err == nil {
+		err = r.validateAddress(ctx, &tmc)
+	}
+	if err != nil {
+		var listErr listError
+		if errors.As(err, &listErr) {
+			return r.syncFailed(ctx, &tmc, err)
+		}
+		log.Info("invalid TinyMonCheck spec", "error", err.Error())
+		r.setCondition(&tmc, ConditionValid, metav1.ConditionFalse, "InvalidSpec", err.Error())
+		r.setCondition(&tmc, ConditionSynced, metav1.ConditionFalse, "InvalidSpec", "Not synced because the spec is invalid")
+		// Retrying doesn't help until the spec is changed.
+		return ctrl.Result{}, r.Status().Update(ctx, &tmc)
+	}
+	r.setCondition(&tmc, ConditionValid, metav1.ConditionTrue, "Valid", "Spec is valid")
+
+	addr := r.address(&tmc)
+
+	// The host address changed: remove the old host first, unless the
+	// TinyMonCheck couldn't have created it.
+	if tmc.Status.Address != "" && tmc.Status.Address != addr && !r.ownAddress(&tmc, tmc.Status.Address) {
+		log.Info("not removing old host outside the TinyMonCheck's namespace", "old", tmc.Status.Address)
+		tmc.Status.Address = ""
+		tmc.Status.Checks = nil
+	}
+	if tmc.Status.Address != "" && tmc.Status.Address != addr {
+		log.Info("host address changed, removing old host", "old", tmc.Status.Address, "address", addr)
+		if err := api.DeleteHost(tmc.Status.Address); err != nil {
+			return r.syncFailed(ctx, &tmc, err)
+		}
+		r.reportDeleted(ctx, r.Client, nil, tmc.Status.Address)
+		tmc.Status.Address = ""
+		tmc.Status.Checks = nil
+	}
+
+	host := r.host(ctx, &tmc)
+
+	log.Info("syncing TinyMonCheck to TinyMon", "address", addr)
+	if err := api.UpsertHost(host); err != nil {
+		log.Error(err, "failed to upsert host")
+		return r.syncFailed(ctx, &tmc, err)
+	}
+	tmc.Status.Address = addr
+
+	desired := make(map[string]bool, len(checks))
+	var synced []string
+	for _, check := range checks {
+		if err := api.UpsertCheck(check); err != nil {
+			log.Error(err, "failed to upsert check", "type", check.Type)
+			return r.syncFailed(ctx, &tmc, err)
+		}
+		desired[check.Type] = true
+		synced = append(synced, check.Type)
+	}
+
+	// Remove checks that were dropped from the spec
+	for _, checkType := range tmc.Status.Checks {
+		if desired[checkType] {
+			continue
+		}
+		if err := api.DeleteCheck(addr, checkType); err != nil {
+			log.Error(err, "failed to delete stale check", "type", checkType)
+			return r.syncFailed(ctx, &tmc, err)
+		}
+	}
+
+	tmc.Status.Checks = synced
+	tmc.Status.ObservedGeneration = tmc.Generation
+	if r.DryRun {
+		r.setCondition(&tmc, ConditionSynced, metav1.ConditionFalse, "DryRun", fmt.Sprintf("%d checks would be synced to TinyMon, not sent in dry-run mode", len(synced)))
+	} else {
+		now := metav1.Now()
+		tmc.Status.LastSyncTime = &now
+		r.setCondition(&tmc, ConditionSynced, metav1.ConditionTrue, "Synced", fmt.Sprintf("%d checks synced to TinyMon", len(synced)))
+	}
+	if err := r.Status().Update(ctx, &tmc); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.reportSynced(ctx, r.Client, KindTinyMonCheck, &tmc, addr, nil)
+
+	// Re-sync periodically to restore hosts or checks removed in TinyMon.
+	return ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
+}
+
+// address returns the TinyMon host address of a TinyMonCheck.
+func (r *TinyMonCheckReconciler) address(tmc *tinymonv1alpha1.TinyMonCheck) string {
+	if tmc.Spec.Host.Address != "" {
+		return tmc.Spec.Host.Address
+	}
+	return resourceAddress(r.Cluster, "check", tmc.Namespace, tmc.Name)
+}
+
+// ownAddress reports whether addr is in the address space of the
+// TinyMonCheck's namespace, k8s://<cluster>/check/<namespace>/, so it can't
+// touch the hosts of other objects, namespaces or clusters.
+func (r *TinyMonCheckReconciler) ownAddress(tmc *tinymonv1alpha1.TinyMonCheck, addr string) bool {
+	prefix := resourceAddress(r.Cluster, "check", tmc.Namespace, "")
+	return len(addr) > len(prefix) && strings.HasPrefix(addr, prefix)
+}
+
+// listError is a failure to list the other TinyMonChecks, which unlike an
+// invalid address is retried.
+type listError struct{ error }
+
+// validateAddress rejects a spec.host.address outside the namespace's
+// address space, or used by another TinyMonCheck.
+func (r *TinyMonCheckReconciler) validateAddress(ctx context.Context, tmc *tinymonv1alpha1.TinyMonCheck) error {
+	addr := r.address(tmc)
+	if !r.ownAddress(tmc, addr) {
+		return fmt.Errorf("spec.host.address %q must start with %s", addr, resourceAddress(r.Cluster, "check", tmc.Namespace, ""))
+	}
+	var list tinymonv1alpha1.TinyMonCheckList
+	if err := r.List(ctx, &list, client.InNamespace(tmc.Namespace)); err != nil {
+		return listError{err}
+	}
+	for i := range list.Items {
+		other := &list.Items[i]
+		if other.Name == tmc.Name {
+			continue
+		}
+		if r.address(other) == addr || other.Status.Address == addr {
+			return fmt.Errorf("spec.host.address %q is already used by TinyMonCheck %s", addr, other.Name)
+		}
+	}
+	return nil
+}
+
+// host returns the TinyMon host of a TinyMonCheck.
+func (r *TinyMonCheckReconciler) host(ctx context.Context, tmc *tinymonv1alpha1.TinyMonCheck) tinymon.Host {
+	topic := tmc.Spec.Host.Topic
+	if topic == "" {
+		topic = defaultTopic(r.Cluster, "checks", tmc.Namespace, nil)
+	}
+	description := tmc.Spec.Host.Description
+	if description == "" {
+		description = fmt.Sprintf("TinyMonCheck %s/%s", tmc.Namespace, tmc.Name)
+	}
+	name := tmc.Spec.Host.Name
+	if name == "" {
+		name = tmc.Name
+	}
+	data := r.templateData(ctx, r.Client, KindTinyMonCheck, tmc, tmc.Annotations, nil)
+	labels := r.hostLabels("check", tmc, data, nil)
+	for k, v := range tmc.Spec.Host.Labels {
+		labels[k] = v
+	}
+	return tinymon.Host{
+		Name:        name,
+		Address:     r.address(tmc),
+		Description: description,
+		Topic:       topic,
+		Labels:      labels,
+		Enabled:     1,
+	}
+}
+
+// desiredChecks validates the spec and converts it into TinyMon checks.
+func (r *TinyMonCheckReconciler) desiredChecks(tmc *tinymonv1alpha1.TinyMonCheck) ([]tinymon.Check, error) {
+	if len(tmc.Spec.Checks) == 0 {
+		return nil, fmt.Errorf("spec.checks must not be empty")
+	}
+	addr := r.address(tmc)
+	seen := make(map[string]bool)
+	var checks []tinymon.Check
+	for i, c := range tmc.Spec.Checks {
+		if !checkTypePattern.MatchString(c.Type) {
+			return nil, fmt.Errorf("spec.checks[%d].type %q is invalid", i, c.Type)
+		}
+		if seen[c.Type] {
+			return nil, fmt.Errorf("spec.checks[%d].type %q is used more than once", i, c.Type)
+		}
+		seen[c.Type] = true
+
+		interval := 60
+		if c.IntervalSeconds != 0 {
+			if c.IntervalSeconds < 30 {
+				return nil, fmt.Errorf("spec.checks[%d].intervalSeconds must be at least 30", i)
+			}
+			interval = int(c.IntervalSeconds)
+		}
+
+		var cfg map[string]interface{}
+		if c.Config != nil && len(c.Config.Raw) > 0 {
+			if err := json.Unmarshal(c.Config.Raw, &cfg); err != nil {
+				return nil, fmt.Errorf("spec.checks[%d].config must be an object: %v", i, err)
+			}
+		}
+
+		enabled := 1
+		if c.Enabled != nil && !*c.Enabled {
+			enabled = 0
+		}
+
+		check := tinymon.Check{
+			HostAddress:     addr,
+			Type:            c.Type,
+			IntervalSeconds: interval,
+			Enabled:         enabled,
+		}
+		if cfg != nil {
+			check.Config = cfg
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// syncFailed records a failed sync in the status and returns the error so the
+// request is retried with backoff.
+func (r *TinyMonCheckReconciler) syncFailed(ctx context.Context, tmc *tinymonv1alpha1.TinyMonCheck, err error) (ctrl.Result, error) {
+	r.setCondition(tmc, ConditionSynced, metav1.ConditionFalse, "SyncFailed", err.Error())
+	r.reportFailed(ctx, r.Client, KindTinyMonCheck, tmc, tmc.Status.Address, err)
+	if updateErr := r.Status().Update(ctx, tmc); updateErr != nil {
+		log.FromContext(ctx).Error(updateErr, "failed to update TinyMonCheck status")
+	}
+	return ctrl.Result{}, err
+}
+
+func (r *TinyMonCheckReconciler) setCondition(tmc *tinymonv1alpha1.TinyMonCheck, condType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&tmc.Status.Conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: tmc.Generation,
+	})
+}
@@ -0,0 +1,120 @@
+package debug
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Server serves the state of all managed hosts on /debug/hosts as JSON, or
+// as HTML for browsers and ?format=html. Requests must authenticate with
+// Token, either as a bearer token or as the password of basic auth. It
+// implements manager.Runnable.
+type Server struct {
+	Addr  string
+	Token string
+	State *State
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	log := ctrl.Log.WithName("debug-server")
+	mux := http.NewServeMux()
+	mux.Handle("/debug/hosts", s)
+	srv := &http.Server{Addr: s.Addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Info("serving debug endpoint", "addr", s.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection returns false, so the endpoint runs on every replica.
+// Each replica lists the hosts it syncs: all of them, or with sharding the
+// ones of its shard.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="tinymon-operator"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	hosts := s.State.Hosts()
+	if r.URL.Query().Get("format") == "html" ||
+		(r.URL.Query().Get("format") == "" && strings.Contains(r.Header.Get("Accept"), "text/html")) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = hostsPage.Execute(w, hosts)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(hosts)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token := ""
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	} else if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = v
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+var hostsPage = template.Must(template.New("hosts").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>tinymon-operator hosts</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+.ok { color: #080; } .warning { color: #b80; } .critical { color: #c00; } .unknown { color: #888; } .error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Managed hosts ({{len .}})</h1>
+<table>
+<tr><th>Host</th><th>Source</th><th>Checks</th><th>Results</th><th>Last sync</th><th>Last error</th><th>Next reconcile</th></tr>
+{{- range .}}
+<tr>
+<td>{{.Name}}<br><code>{{.Address}}</code></td>
+<td>{{with .Source}}{{.Kind}} {{if .Namespace}}{{.Namespace}}/{{end}}{{.Name}}{{end}}</td>
+<td>{{range .Checks}}{{.Type}} every {{.IntervalSeconds}}s<br>{{end}}</td>
+<td>{{range .Results}}{{.CheckType}}: <span class="{{.Status}}">{{.Status}}</span> {{.Message}}<br>{{end}}</td>
+<td>{{time .LastSync}}</td>
+<td class="error">{{if .LastError}}{{time .LastErrorTime}}: {{.LastError}}{{end}}</td>
+<td>{{time .NextReconcile}}</td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,237 @@
+package debug
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+)
+
+// Source is the Kubernetes object a host was created for.
+type Source struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// Host is the in-memory state of a managed host.
+type Host struct {
+	Address       string           `json:"address"`
+	Name          string           `json:"name"`
+	Topic         string           `json:"topic,omitempty"`
+	Source        *Source          `json:"source,omitempty"`
+	Checks        []tinymon.Check  `json:"checks"`
+	Results       []tinymon.Result `json:"results"`
+	LastSync      time.Time        `json:"last_sync,omitzero"`
+	LastError     string           `json:"last_error,omitempty"`
+	LastErrorTime time.Time        `json:"last_error_time,omitzero"`
+	NextReconcile time.Time        `json:"next_reconcile,omitzero"`
+}
+
+// host is the mutable state behind a Host.
+type host struct {
+	Host
+	checks  map[string]syncedCheck    // type/config -> check
+	results map[string]tinymon.Result // check type -> result
+	// sync counts the upserts of the host, each starts a sync.
+	sync int
+}
+
+// syncedCheck is a check and the sync it was last upserted in.
+type syncedCheck struct {
+	tinymon.Check
+	sync int
+}
+
+// State keeps what the controllers last sent to TinyMon for every managed
+// host, for the debug endpoint. A nil State records nothing.
+type State struct {
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+func NewState() *State {
+	return &State{hosts: make(map[string]*host)}
+}
+
+// get returns the host at addr, creating it if needed. Callers must hold s.mu.
+func (s *State) get(addr string) *host {
+	h, ok := s.hosts[addr]
+	if !ok {
+		h = &host{
+			Host:    Host{Address: addr},
+			checks:  make(map[string]syncedCheck),
+			results: make(map[string]tinymon.Result),
+		}
+		s.hosts[addr] = h
+	}
+	return h
+}
+
+// Synced records a successful sync of the host at addr for src. Checks not
+// upserted since the host was, e.g. of a rule removed from an Ingress, are
+// no longer synced and dropped.
+func (s *State) Synced(addr string, src Source) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.get(addr)
+	for key, c := range h.checks {
+		if c.sync < h.sync {
+			delete(h.checks, key)
+		}
+	}
+	h.Source = &src
+	h.LastSync = time.Now()
+	h.LastError = ""
+	h.LastErrorTime = time.Time{}
+}
+
+// Failed records a failed sync of the host at addr for src.
+func (s *State) Failed(addr string, src Source, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.get(addr)
+	h.Source = &src
+	h.LastError = err.Error()
+	h.LastErrorTime = time.Now()
+}
+
+// Scheduled records when the host at addr is reconciled next. A zero time
+// means it isn't scheduled, e.g. because the reconcile is retried with
+// backoff.
+func (s *State) Scheduled(addr string, next time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.hosts[addr]; ok {
+		h.NextReconcile = next
+	}
+}
+
+// Deleted forgets the host at addr.
+func (s *State) Deleted(addr string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.hosts, addr)
+}
+
+// Hosts returns a snapshot of all hosts, sorted by address.
+func (s *State) Hosts() []Host {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hosts := make([]Host, 0, len(s.hosts))
+	for _, h := range s.hosts {
+		out := h.Host
+		out.Checks = make([]tinymon.Check, 0, len(h.checks))
+		for _, c := range h.checks {
+			out.Checks = append(out.Checks, c.Check)
+		}
+		sort.Slice(out.Checks, func(i, j int) bool { return out.Checks[i].Type < out.Checks[j].Type })
+		out.Results = make([]tinymon.Result, 0, len(h.results))
+		for _, r := range h.results {
+			out.Results = append(out.Results, r)
+		}
+		sort.Slice(out.Results, func(i, j int) bool { return out.Results[i].CheckType < out.Results[j].CheckType })
+		hosts = append(hosts, out)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Address < hosts[j].Address })
+	return hosts
+}
+
+// Wrap returns an API that records hosts, checks and results in s after
+// they were sent through api successfully.
+func (s *State) Wrap(api tinymon.API) tinymon.API {
+	return &recorder{api: api, state: s}
+}
+
+type recorder struct {
+	api   tinymon.API
+	state *State
+}
+
+func (r *recorder) UpsertHost(h tinymon.Host) error {
+	if err := r.api.UpsertHost(h); err != nil {
+		return err
+	}
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	st := r.state.get(h.Address)
+	st.Name = h.Name
+	st.Topic = h.Topic
+	st.sync++
+	return nil
+}
+
+func (r *recorder) DeleteHost(address string) error {
+	if err := r.api.DeleteHost(address); err != nil {
+		return err
+	}
+	r.state.Deleted(address)
+	return nil
+}
+
+func (r *recorder) UpsertCheck(c tinymon.Check) error {
+	if err := r.api.UpsertCheck(c); err != nil {
+		return err
+	}
+	cfg, _ := json.Marshal(c.Config)
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	h := r.state.get(c.HostAddress)
+	h.checks[c.Type+"/"+string(cfg)] = syncedCheck{Check: c, sync: h.sync}
+	return nil
+}
+
+func (r *recorder) DeleteCheck(hostAddress, checkType string) error {
+	if err := r.api.DeleteCheck(hostAddress, checkType); err != nil {
+		return err
+	}
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	if h, ok := r.state.hosts[hostAddress]; ok {
+		for key, c := range h.checks {
+			if c.Type == checkType {
+				delete(h.checks, key)
+			}
+		}
+		delete(h.results, checkType)
+	}
+	return nil
+}
+
+func (r *recorder) PushResult(result tinymon.Result) error {
+	if err := r.api.PushResult(result); err != nil {
+		return err
+	}
+	r.recordResults([]tinymon.Result{result})
+	return nil
+}
+
+func (r *recorder) PushBulk(results []tinymon.Result) error {
+	if err := r.api.PushBulk(results); err != nil {
+		return err
+	}
+	r.recordResults(results)
+	return nil
+}
+
+func (r *recorder) recordResults(results []tinymon.Result) {
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	for _, res := range results {
+		r.state.get(res.HostAddress).results[res.CheckType] = res
+	}
+}
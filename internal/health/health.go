@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// TinyMonChecker is a readiness check that fails once all calls to TinyMon
+// have been failing for longer than Window, e.g. because TinyMon is
+// unreachable or the API key is wrong.
+type TinyMonChecker struct {
+	Client *tinymon.Client
+	Window time.Duration
+}
+
+func (c *TinyMonChecker) Check(_ *http.Request) error {
+	_, failingSince, lastErr := c.Client.Health()
+	if failingSince.IsZero() || time.Since(failingSince) < c.Window {
+		return nil
+	}
+	return fmt.Errorf("TinyMon calls failing since %s: %v", failingSince.Format(time.RFC3339), lastErr)
+}
+
+// Probe periodically pings TinyMon, so readiness reflects TinyMon even when
+// no controller has called it recently. It implements manager.Runnable.
+type Probe struct {
+	Client   *tinymon.Client
+	Interval time.Duration
+}
+
+func (p *Probe) Start(ctx context.Context) error {
+	log := ctrl.Log.WithName("tinymon-probe")
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Client.Ping(); err != nil {
+			log.Error(err, "TinyMon probe failed")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection returns false, every replica reports its own readiness.
+func (p *Probe) NeedLeaderElection() bool {
+	return false
+}
+
+// Workers tracks in-flight reconciles. Its liveness check fails when a
+// reconcile has been running for longer than Timeout, which means a worker
+// is stuck and the operator needs a restart.
+type Workers struct {
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	next     uint64
+	inflight map[uint64]worker
+}
+
+type worker struct {
+	controller string
+	request    reconcile.Request
+	started    time.Time
+}
+
+func NewWorkers(timeout time.Duration) *Workers {
+	return &Workers{Timeout: timeout, inflight: make(map[uint64]worker)}
+}
+
+// Wrap returns a reconciler that tracks every call of r.
+func (w *Workers) Wrap(controller string, r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		w.mu.Lock()
+		id := w.next
+		w.next++
+		w.inflight[id] = worker{controller: controller, request: req, started: time.Now()}
+		w.mu.Unlock()
+
+		defer func() {
+			w.mu.Lock()
+			delete(w.inflight, id)
+			w.mu.Unlock()
+		}()
+		return r.Reconcile(ctx, req)
+	})
+}
+
+func (w *Workers) Check(_ *http.Request) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, wk := range w.inflight {
+		if d := time.Since(wk.started); d > w.Timeout {
+			return fmt.Errorf("%s reconcile of %s running for %s", wk.controller, wk.request.NamespacedName, d.Round(time.Second))
+		}
+	}
+	return nil
+}
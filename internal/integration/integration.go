@@ -0,0 +1,233 @@
+package integration
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var enabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "tinymon_integration_enabled",
+	Help: "Whether an optional integration is running, 1 if its CRDs are installed and 0 otherwise.",
+}, []string{"integration"})
+
+func init() {
+	metrics.Registry.MustRegister(enabled)
+}
+
+// restartDelay is how long a failed integration waits before it is started
+// again.
+const restartDelay = time.Minute
+
+// Integration is an optional controller for resources whose CRDs may not be
+// installed in the cluster.
+type Integration struct {
+	Name string
+	// GroupVersions must all be served by established CRDs for the
+	// integration to run.
+	GroupVersions []schema.GroupVersion
+	// Objects are the types of the integration whose informers are removed
+	// from the cache when it stops, e.g. because the CRDs were deleted.
+	Objects []client.Object
+	// New builds the controller. It is called on every start, the controller
+	// must not be added to the manager.
+	New func() (controller.Controller, error)
+}
+
+// Registry starts and stops integrations as their CRDs appear and disappear.
+// It watches CustomResourceDefinitions and implements manager.Runnable.
+type Registry struct {
+	mgr          ctrl.Manager
+	log          logr.Logger
+	integrations []*entry
+	trigger      chan struct{}
+}
+
+// entry is the runtime state of a registered integration.
+type entry struct {
+	Integration
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewRegistry(mgr ctrl.Manager) *Registry {
+	return &Registry{
+		mgr:     mgr,
+		log:     ctrl.Log.WithName("integrations"),
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// Register adds an integration. It must be called before the manager starts.
+func (r *Registry) Register(i Integration) {
+	r.integrations = append(r.integrations, &entry{Integration: i})
+	enabled.WithLabelValues(i.Name).Set(0)
+}
+
+func (r *Registry) Start(ctx context.Context) error {
+	informer, err := r.mgr.GetCache().GetInformer(ctx, &apiextensionsv1.CustomResourceDefinition{})
+	if err != nil {
+		return err
+	}
+	handle, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { r.notify() },
+		UpdateFunc: func(interface{}, interface{}) { r.notify() },
+		DeleteFunc: func(interface{}) { r.notify() },
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = informer.RemoveEventHandler(handle) }()
+
+	r.notify()
+	for {
+		select {
+		case <-ctx.Done():
+			for _, e := range r.integrations {
+				r.stop(e)
+			}
+			return nil
+		case <-r.trigger:
+			r.sync(ctx)
+		}
+	}
+}
+
+// notify schedules a sync without blocking the informer.
+func (r *Registry) notify() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// sync starts every integration whose CRDs are available and stops the
+// others.
+func (r *Registry) sync(ctx context.Context) {
+	var crds apiextensionsv1.CustomResourceDefinitionList
+	if err := r.mgr.GetCache().List(ctx, &crds); err != nil {
+		r.log.Error(err, "failed to list CustomResourceDefinitions")
+		return
+	}
+	served := servedGroupVersions(crds.Items)
+
+	for _, e := range r.integrations {
+		available := true
+		for _, gv := range e.GroupVersions {
+			if !served[gv] {
+				available = false
+				break
+			}
+		}
+		switch {
+		case available && !e.running():
+			r.start(ctx, e)
+		case !available && e.done != nil:
+			r.log.Info("CRDs removed, stopping integration", "integration", e.Name)
+			r.stop(e)
+		}
+	}
+}
+
+// servedGroupVersions returns the GroupVersions served by established CRDs
+// that are not being deleted.
+func servedGroupVersions(crds []apiextensionsv1.CustomResourceDefinition) map[schema.GroupVersion]bool {
+	served := make(map[schema.GroupVersion]bool)
+	for _, crd := range crds {
+		if crd.DeletionTimestamp != nil || !established(crd) {
+			continue
+		}
+		for _, v := range crd.Spec.Versions {
+			if v.Served {
+				served[schema.GroupVersion{Group: crd.Spec.Group, Version: v.Name}] = true
+			}
+		}
+	}
+	return served
+}
+
+func established(crd apiextensionsv1.CustomResourceDefinition) bool {
+	for _, cond := range crd.Status.Conditions {
+		if cond.Type == apiextensionsv1.Established {
+			return cond.Status == apiextensionsv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// running reports whether the integration's controller is running, which is
+// not the case once it returned on its own.
+func (e *entry) running() bool {
+	if e.done == nil {
+		return false
+	}
+	select {
+	case <-e.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (r *Registry) start(ctx context.Context, e *entry) {
+	log := r.log.WithValues("integration", e.Name)
+	if e.cancel != nil {
+		// The previous controller returned on its own, its context is
+		// released before it is replaced.
+		e.cancel()
+		e.cancel, e.done = nil, nil
+	}
+	c, err := e.New()
+	if err != nil {
+		log.Error(err, "failed to create integration controller")
+		return
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	e.cancel, e.done = cancel, done
+	enabled.WithLabelValues(e.Name).Set(1)
+	log.Info("CRDs available, starting integration", "groupVersions", e.GroupVersions)
+
+	go func() {
+		defer close(done)
+		if err := c.Start(runCtx); err != nil {
+			log.Error(err, "integration controller failed")
+		}
+		// A controller that stops without being cancelled, e.g. because
+		// its caches didn't sync, is restarted after restartDelay.
+		if runCtx.Err() == nil {
+			enabled.WithLabelValues(e.Name).Set(0)
+			time.AfterFunc(restartDelay, r.notify)
+		}
+	}()
+}
+
+// stop cancels the integration's controller, waits for it to return and
+// removes its informers, which would otherwise keep failing to list
+// resources that no longer exist.
+func (r *Registry) stop(e *entry) {
+	if e.done == nil {
+		return
+	}
+	e.cancel()
+	<-e.done
+	e.cancel, e.done = nil, nil
+
+	for _, obj := range e.Objects {
+		if err := r.mgr.GetCache().RemoveInformer(context.Background(), obj); err != nil {
+			r.log.Error(err, "failed to remove informer", "integration", e.Name)
+		}
+	}
+	enabled.WithLabelValues(e.Name).Set(0)
+	r.log.Info("integration stopped", "integration", e.Name)
+}
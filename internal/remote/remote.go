@@ -0,0 +1,313 @@
+package remote
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// LabelRemoteCluster marks the Secrets in the operator's namespace that hold
+// the kubeconfig of a remote cluster.
+const LabelRemoteCluster = "tinymon.io/remote-cluster"
+
+// Keys of a remote cluster Secret.
+const (
+	KeyKubeconfig  = "kubeconfig"
+	KeyClusterName = "cluster-name"
+)
+
+// Event reasons emitted on remote cluster Secrets.
+const (
+	ReasonConnected        = "Connected"
+	ReasonConnectionFailed = "ConnectionFailed"
+	ReasonInvalidSecret    = "InvalidSecret"
+)
+
+var connected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "tinymon_remote_cluster_connected",
+	Help: "Whether a remote cluster is monitored, 1 once its caches synced and 0 while it is failing.",
+}, []string{"cluster"})
+
+func init() {
+	metrics.Registry.MustRegister(connected)
+}
+
+// Selector selects the remote cluster Secrets, so the cache only holds them.
+func Selector() labels.Selector {
+	req, err := labels.NewRequirement(LabelRemoteCluster, selection.Exists, nil)
+	if err != nil {
+		panic(err)
+	}
+	return labels.NewSelector().Add(*req)
+}
+
+// restartDelay is how long a failed remote cluster waits before it is
+// started again.
+const restartDelay = time.Minute
+
+// syncTimeout bounds the wait for the caches of a remote cluster.
+const syncTimeout = 2 * time.Minute
+
+// NewManager builds the manager of a remote cluster with all controllers
+// added. It is called on every start, a manager can't be started twice.
+type NewManager func(cluster string, cfg *rest.Config) (manager.Manager, error)
+
+// Clusters starts a manager for every remote cluster Secret and stops it
+// when the Secret is deleted or changed. It implements manager.Runnable.
+type Clusters struct {
+	mgr        ctrl.Manager
+	namespace  string
+	local      string
+	newManager NewManager
+	recorder   events.EventRecorder
+	log        logr.Logger
+	trigger    chan struct{}
+
+	clusters map[types.NamespacedName]*entry
+}
+
+// entry is the runtime state of a remote cluster.
+type entry struct {
+	cluster string
+	hash    [sha256.Size]byte
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// New returns Clusters for the Secrets in namespace. The manager's cache must
+// be able to watch Secrets there. local is the name of the operator's own
+// cluster, which remote clusters can't reuse.
+func New(mgr ctrl.Manager, namespace, local string, newManager NewManager) *Clusters {
+	return &Clusters{
+		mgr:        mgr,
+		namespace:  namespace,
+		local:      local,
+		newManager: newManager,
+		recorder:   mgr.GetEventRecorder("tinymon-operator"),
+		log:        ctrl.Log.WithName("remote-clusters"),
+		trigger:    make(chan struct{}, 1),
+		clusters:   make(map[types.NamespacedName]*entry),
+	}
+}
+
+func (c *Clusters) Start(ctx context.Context) error {
+	informer, err := c.mgr.GetCache().GetInformer(ctx, &corev1.Secret{})
+	if err != nil {
+		return err
+	}
+	handle, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.notify() },
+		UpdateFunc: func(interface{}, interface{}) { c.notify() },
+		DeleteFunc: func(interface{}) { c.notify() },
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = informer.RemoveEventHandler(handle) }()
+
+	c.notify()
+	for {
+		select {
+		case <-ctx.Done():
+			for key, e := range c.clusters {
+				c.stop(key, e)
+			}
+			return nil
+		case <-c.trigger:
+			c.sync(ctx)
+		}
+	}
+}
+
+// notify schedules a sync without blocking the informer.
+func (c *Clusters) notify() {
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// sync starts a manager for every new or changed Secret and stops the
+// managers of changed and deleted ones.
+func (c *Clusters) sync(ctx context.Context) {
+	var secrets corev1.SecretList
+	if err := c.mgr.GetCache().List(ctx, &secrets, client.InNamespace(c.namespace), client.HasLabels{LabelRemoteCluster}); err != nil {
+		c.log.Error(err, "failed to list remote cluster Secrets")
+		return
+	}
+	// Sorted, so the older Secret keeps a cluster name used twice.
+	sort.Slice(secrets.Items, func(i, j int) bool {
+		return secrets.Items[i].CreationTimestamp.Before(&secrets.Items[j].CreationTimestamp)
+	})
+
+	seen := make(map[types.NamespacedName]bool)
+	names := map[string]bool{c.local: true}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		key := client.ObjectKeyFromObject(secret)
+		seen[key] = true
+		cluster := string(secret.Data[KeyClusterName])
+		hash := sha256.Sum256(append(append([]byte(cluster), 0), secret.Data[KeyKubeconfig]...))
+
+		e := c.clusters[key]
+		if e != nil && e.hash != hash {
+			c.log.Info("remote cluster Secret changed, restarting", "secret", key, "cluster", e.cluster)
+			c.stop(key, e)
+			e = nil
+		}
+		if e != nil && names[e.cluster] {
+			// Another Secret took the name while this one was failing.
+			c.stop(key, e)
+			e = nil
+		}
+		if e != nil {
+			names[e.cluster] = true
+			if !e.running() {
+				c.start(ctx, key, secret, e)
+			}
+			continue
+		}
+
+		cfg, err := parseSecret(secret)
+		if err == nil && names[cluster] {
+			err = fmt.Errorf("cluster name %q is already in use", cluster)
+		}
+		if err != nil {
+			c.log.Error(err, "invalid remote cluster Secret", "secret", key)
+			c.recorder.Eventf(secret, nil, corev1.EventTypeWarning, ReasonInvalidSecret, "Validate", "Ignoring remote cluster: %v", err)
+			continue
+		}
+		names[cluster] = true
+		e = &entry{cluster: cluster, hash: hash}
+		c.clusters[key] = e
+		c.startWith(ctx, key, secret, e, cfg)
+	}
+
+	for key, e := range c.clusters {
+		if !seen[key] {
+			c.log.Info("remote cluster Secret deleted, stopping", "secret", key, "cluster", e.cluster)
+			c.stop(key, e)
+		}
+	}
+}
+
+// parseSecret validates a remote cluster Secret and returns the REST config
+// of its kubeconfig.
+func parseSecret(secret *corev1.Secret) (*rest.Config, error) {
+	if len(secret.Data[KeyClusterName]) == 0 {
+		return nil, fmt.Errorf("key %s is missing", KeyClusterName)
+	}
+	if len(secret.Data[KeyKubeconfig]) == 0 {
+		return nil, fmt.Errorf("key %s is missing", KeyKubeconfig)
+	}
+	cfg, err := clientcmd.RESTConfigFromKubeConfig(secret.Data[KeyKubeconfig])
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+	return cfg, nil
+}
+
+// running reports whether the cluster's manager is running, which is not the
+// case once it returned on its own.
+func (e *entry) running() bool {
+	if e.done == nil {
+		return false
+	}
+	select {
+	case <-e.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// start restarts the manager of a cluster that failed.
+func (c *Clusters) start(ctx context.Context, key types.NamespacedName, secret *corev1.Secret, e *entry) {
+	cfg, err := parseSecret(secret)
+	if err != nil {
+		// The Secret hasn't changed since it was valid.
+		c.log.Error(err, "invalid remote cluster Secret", "secret", key)
+		return
+	}
+	c.startWith(ctx, key, secret, e, cfg)
+}
+
+func (c *Clusters) startWith(ctx context.Context, key types.NamespacedName, secret *corev1.Secret, e *entry, cfg *rest.Config) {
+	log := c.log.WithValues("secret", key, "cluster", e.cluster)
+	connected.WithLabelValues(e.cluster).Set(0)
+	m, err := c.newManager(e.cluster, cfg)
+	if err != nil {
+		log.Error(err, "failed to create manager for remote cluster")
+		c.recorder.Eventf(secret, nil, corev1.EventTypeWarning, ReasonConnectionFailed, "Connect", "Failed to connect to cluster %s: %v", e.cluster, err)
+		e.done = nil
+		time.AfterFunc(restartDelay, c.notify)
+		return
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	e.cancel, e.done = cancel, done
+	log.Info("starting remote cluster")
+
+	go func() {
+		syncCtx, cancel := context.WithTimeout(runCtx, syncTimeout)
+		defer cancel()
+		if m.GetCache().WaitForCacheSync(syncCtx) {
+			connected.WithLabelValues(e.cluster).Set(1)
+			log.Info("remote cluster connected")
+			c.recorder.Eventf(secret, nil, corev1.EventTypeNormal, ReasonConnected, "Connect", "Monitoring cluster %s", e.cluster)
+			return
+		}
+		if runCtx.Err() == nil {
+			// The manager keeps trying, so does the cache.
+			c.recorder.Eventf(secret, nil, corev1.EventTypeWarning, ReasonConnectionFailed, "Connect", "Caches of cluster %s didn't sync within %s", e.cluster, syncTimeout)
+		}
+	}()
+
+	go func() {
+		defer close(done)
+		err := m.Start(runCtx)
+		if runCtx.Err() != nil {
+			return
+		}
+		// A manager that stops without being cancelled, e.g. because the
+		// cluster is unreachable, is restarted after restartDelay.
+		if err == nil {
+			err = errors.New("manager stopped")
+		}
+		connected.WithLabelValues(e.cluster).Set(0)
+		log.Error(err, "remote cluster failed")
+		c.recorder.Eventf(secret, nil, corev1.EventTypeWarning, ReasonConnectionFailed, "Connect", "Cluster %s failed: %v", e.cluster, err)
+		time.AfterFunc(restartDelay, c.notify)
+	}()
+}
+
+// stop cancels the cluster's manager, waits for it to return and forgets
+// the cluster.
+func (c *Clusters) stop(key types.NamespacedName, e *entry) {
+	if e.done != nil {
+		e.cancel()
+		<-e.done
+	}
+	delete(c.clusters, key)
+	connected.DeleteLabelValues(e.cluster)
+	c.log.Info("remote cluster stopped", "secret", key, "cluster", e.cluster)
+}
@@ -0,0 +1,310 @@
+package shard
+
+import (
+	"context"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// LabelShardGroup marks the Leases of the replicas sharing the monitored
+// objects. Its value is the group.
+const LabelShardGroup = "tinymon.io/shard-group"
+
+const (
+	// leaseDuration is how long a replica is a member after its last renewal.
+	leaseDuration = 30 * time.Second
+	// renewInterval is how often a replica renews its Lease.
+	renewInterval = 10 * time.Second
+	// settle is how long a replica waits before it takes over objects from a
+	// replica that is still a member, so the other replica has seen the new
+	// membership and stopped reconciling them.
+	settle = 10 * time.Second
+)
+
+var members = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "tinymon_shard_members",
+	Help: "Number of operator replicas sharing the monitored objects, as seen by this replica.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(members)
+}
+
+// Selector selects the Leases of group, so the cache only holds them.
+func Selector(group string) labels.Selector {
+	return labels.SelectorFromSet(labels.Set{LabelShardGroup: group})
+}
+
+// Ring assigns every object to one replica by rendezvous hashing over the
+// replicas holding a Lease of the group, so a replica joining or leaving only
+// moves the objects it gains or had. It implements manager.Runnable. A nil
+// Ring owns every object.
+type Ring struct {
+	mgr       ctrl.Manager
+	namespace string
+	group     string
+	identity  string
+	log       logr.Logger
+	trigger   chan struct{}
+
+	mu sync.Mutex
+	// current are the members, sorted. previous are the members before the
+	// last change, changed is when it happened.
+	current, previous []string
+	changed           time.Time
+	renewed           time.Time
+	stopped           bool
+	subscribers       map[chan struct{}]bool
+}
+
+// New returns a Ring for the Leases of group in namespace. The manager's
+// cache must be able to watch Leases there. identity names this replica and
+// must be unique in the group, e.g. the Pod name.
+func New(mgr ctrl.Manager, namespace, group, identity string) *Ring {
+	return &Ring{
+		mgr:         mgr,
+		namespace:   namespace,
+		group:       group,
+		identity:    identity,
+		log:         ctrl.Log.WithName("shard"),
+		trigger:     make(chan struct{}, 1),
+		subscribers: make(map[chan struct{}]bool),
+	}
+}
+
+func (r *Ring) Start(ctx context.Context) error {
+	informer, err := r.mgr.GetCache().GetInformer(ctx, &coordinationv1.Lease{})
+	if err != nil {
+		return err
+	}
+	handle, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { r.notify() },
+		UpdateFunc: func(interface{}, interface{}) { r.notify() },
+		DeleteFunc: func(interface{}) { r.notify() },
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = informer.RemoveEventHandler(handle) }()
+
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+	r.renew(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			r.stop()
+			return nil
+		case <-ticker.C:
+			r.renew(ctx)
+		case <-r.trigger:
+		}
+		r.sync(ctx)
+	}
+}
+
+// notify schedules a sync without blocking the informer.
+func (r *Ring) notify() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Ring) leaseKey() types.NamespacedName {
+	return types.NamespacedName{Namespace: r.namespace, Name: r.group + "-" + r.identity}
+}
+
+// renew creates or renews this replica's Lease.
+func (r *Ring) renew(ctx context.Context) {
+	now := time.Now()
+	renewTime := metav1.NewMicroTime(now)
+	seconds := int32(leaseDuration / time.Second)
+
+	var lease coordinationv1.Lease
+	err := r.mgr.GetAPIReader().Get(ctx, r.leaseKey(), &lease)
+	switch {
+	case errors.IsNotFound(err):
+		lease = coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      r.leaseKey().Name,
+				Namespace: r.namespace,
+				Labels:    map[string]string{LabelShardGroup: r.group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &r.identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &renewTime,
+				RenewTime:            &renewTime,
+			},
+		}
+		err = r.mgr.GetClient().Create(ctx, &lease)
+	case err == nil:
+		lease.Spec.HolderIdentity = &r.identity
+		lease.Spec.LeaseDurationSeconds = &seconds
+		lease.Spec.RenewTime = &renewTime
+		err = r.mgr.GetClient().Update(ctx, &lease)
+	}
+	if err != nil {
+		r.log.Error(err, "failed to renew shard Lease", "lease", r.leaseKey())
+		return
+	}
+	r.mu.Lock()
+	r.renewed = now
+	r.mu.Unlock()
+}
+
+// sync reads the members from the Leases and tells the subscribers when they
+// changed.
+func (r *Ring) sync(ctx context.Context) {
+	var leases coordinationv1.LeaseList
+	if err := r.mgr.GetCache().List(ctx, &leases, client.InNamespace(r.namespace), client.MatchingLabels{LabelShardGroup: r.group}); err != nil {
+		r.log.Error(err, "failed to list shard Leases")
+		return
+	}
+	now := time.Now()
+	var current []string
+	for _, lease := range leases.Items {
+		id := lease.Spec.HolderIdentity
+		if id == nil || *id == r.identity || lease.DeletionTimestamp != nil || !alive(lease.Spec, now) {
+			continue
+		}
+		current = append(current, *id)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// This replica counts itself until the others consider its Lease
+	// expired, minus the time they may need to notice.
+	if !r.stopped && now.Sub(r.renewed) < leaseDuration-settle {
+		current = append(current, r.identity)
+	}
+	slices.Sort(current)
+	current = slices.Compact(current)
+	if slices.Equal(current, r.current) {
+		return
+	}
+	// Changes in quick succession are settled against the members before
+	// the first of them.
+	if now.Sub(r.changed) >= settle {
+		r.previous = r.current
+	}
+	r.current, r.changed = current, now
+	members.Set(float64(len(current)))
+	r.log.Info("shard members changed", "members", current)
+	for ch := range r.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// alive reports whether a Lease was renewed within its duration.
+func alive(spec coordinationv1.LeaseSpec, now time.Time) bool {
+	if spec.RenewTime == nil {
+		return false
+	}
+	d := leaseDuration
+	if spec.LeaseDurationSeconds != nil {
+		d = time.Duration(*spec.LeaseDurationSeconds) * time.Second
+	}
+	return now.Before(spec.RenewTime.Add(d))
+}
+
+// stop leaves the group, so the other replicas take over at once instead of
+// waiting for the Lease to expire.
+func (r *Ring) stop() {
+	r.mu.Lock()
+	r.stopped = true
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: r.leaseKey().Name, Namespace: r.namespace}}
+	if err := r.mgr.GetClient().Delete(ctx, lease); err != nil && !errors.IsNotFound(err) {
+		r.log.Error(err, "failed to delete shard Lease", "lease", r.leaseKey())
+	}
+}
+
+// Owns reports whether this replica reconciles the object with key. An
+// object taken over from a replica that is still a member is owned once the
+// membership settled, until then wait is the time left.
+func (r *Ring) Owns(key string) (owned bool, wait time.Duration) {
+	if r == nil {
+		return true, 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped || owner(r.current, key) != r.identity {
+		return false, 0
+	}
+	left := settle - time.Since(r.changed)
+	if left <= 0 {
+		return true, 0
+	}
+	previous := owner(r.previous, key)
+	if previous == r.identity || (previous != "" && !slices.Contains(r.current, previous)) {
+		// Kept, or taken over from a replica that left.
+		return true, 0
+	}
+	return false, left
+}
+
+// owner returns the member with the highest hash of member and key.
+func owner(members []string, key string) string {
+	var best string
+	var bestHash uint64
+	for _, m := range members {
+		h := fnv.New64a()
+		h.Write([]byte(m))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if sum := mix(h.Sum64()); best == "" || sum > bestHash {
+			best, bestHash = m, sum
+		}
+	}
+	return best
+}
+
+// mix spreads the bits of an FNV hash, whose high bits barely change between
+// similar inputs.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// Subscribe returns a channel that receives when the members change, and a
+// function to stop receiving.
+func (r *Ring) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	if r == nil {
+		return ch, func() {}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers[ch] = true
+	return ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.subscribers, ch)
+	}
+}
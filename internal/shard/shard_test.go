@@ -0,0 +1,88 @@
+package shard
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func keys(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("prod/shop/deployment-%d", i)
+	}
+	return out
+}
+
+func TestOwner(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+	}{
+		{name: "one member", members: []string{"a"}},
+		{name: "two members", members: []string{"a", "b"}},
+		{name: "similar names", members: []string{"operator-0", "operator-1", "operator-2"}},
+		{name: "many members", members: []string{"a", "b", "c", "d", "e", "f", "g", "h"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := make(map[string]int)
+			reversed := slices.Clone(tt.members)
+			slices.Reverse(reversed)
+			for _, key := range keys(1000) {
+				got := owner(tt.members, key)
+				if !slices.Contains(tt.members, got) {
+					t.Fatalf("owner(%v, %q) = %q, not a member", tt.members, key, got)
+				}
+				if again := owner(reversed, key); again != got {
+					t.Fatalf("owner of %q depends on the order of the members: %q and %q", key, got, again)
+				}
+				counts[got]++
+			}
+			// Every member gets a share, at least half of an even one.
+			for _, m := range tt.members {
+				if want := 1000 / len(tt.members) / 2; counts[m] < want {
+					t.Errorf("member %q owns %d of 1000 keys, want at least %d", m, counts[m], want)
+				}
+			}
+		})
+	}
+}
+
+func TestOwnerNoMembers(t *testing.T) {
+	if got := owner(nil, "prod/shop/web"); got != "" {
+		t.Errorf("owner(nil) = %q, want none", got)
+	}
+}
+
+func TestOwnerStability(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+		change  []string
+	}{
+		{name: "member removed", members: []string{"a", "b", "c"}, change: []string{"a", "c"}},
+		{name: "last member removed", members: []string{"a", "b", "c"}, change: []string{"a", "b"}},
+		{name: "member added", members: []string{"a", "b"}, change: []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moved := 0
+			for _, key := range keys(1000) {
+				before, after := owner(tt.members, key), owner(tt.change, key)
+				if before == after {
+					continue
+				}
+				moved++
+				// Only keys of a leaving member, or keys taken by a joining
+				// member, move.
+				if slices.Contains(tt.change, before) && slices.Contains(tt.members, after) {
+					t.Errorf("key %q moved from %q to %q, which both stayed", key, before, after)
+				}
+			}
+			if moved == 0 {
+				t.Error("no key moved")
+			}
+		})
+	}
+}
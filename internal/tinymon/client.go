@@ -6,13 +6,30 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 )
 
+// API is implemented by Client and by DryRun, which records mutations
+// instead of sending them.
+type API interface {
+	UpsertHost(host Host) error
+	DeleteHost(address string) error
+	UpsertCheck(check Check) error
+	DeleteCheck(hostAddress, checkType string) error
+	PushResult(result Result) error
+	PushBulk(results []Result) error
+}
+
 type Client struct {
 	baseURL    string
 	apiKey     string
 	httpClient *http.Client
+
+	mu           sync.Mutex
+	lastSuccess  time.Time
+	failingSince time.Time
+	lastErr      error
 }
 
 func NewClient(baseURL, apiKey string) *Client {
@@ -73,10 +90,21 @@ func (c *Client) do(method, path string, body interface{}) ([]byte, int, error)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, 0, fmt.Errorf("do request: %w", err)
+		err = fmt.Errorf("do request: %w", err)
+		c.recordOutcome(err)
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		c.recordOutcome(fmt.Errorf("%s %s: authentication failed with status %d", method, path, resp.StatusCode))
+	case resp.StatusCode >= 500:
+		c.recordOutcome(fmt.Errorf("%s %s: server error %d", method, path, resp.StatusCode))
+	default:
+		c.recordOutcome(nil)
+	}
+
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, resp.StatusCode, fmt.Errorf("read response: %w", err)
@@ -85,6 +113,44 @@ func (c *Client) do(method, path string, body interface{}) ([]byte, int, error)
 	return respBody, resp.StatusCode, nil
 }
 
+// recordOutcome tracks whether TinyMon was reachable and accepted the API key.
+func (c *Client) recordOutcome(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.lastSuccess = time.Now()
+		c.failingSince = time.Time{}
+		c.lastErr = nil
+		return
+	}
+	if c.failingSince.IsZero() {
+		c.failingSince = time.Now()
+	}
+	c.lastErr = err
+}
+
+// Health returns the time of the last successful call, the time since which
+// all calls have failed (zero if the last call succeeded) and the last error.
+func (c *Client) Health() (lastSuccess, failingSince time.Time, lastErr error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastSuccess, c.failingSince, c.lastErr
+}
+
+// Ping sends an empty bulk push to verify that TinyMon is reachable and the
+// API key is accepted. It doesn't change anything in TinyMon. The push API
+// has no health endpoint, so this uses the endpoint results are pushed to.
+func (c *Client) Ping() error {
+	_, code, err := c.do("POST", "/api/push/bulk", BulkRequest{Results: []Result{}})
+	if err != nil {
+		return err
+	}
+	if code < 200 || code > 299 {
+		return fmt.Errorf("ping: unexpected status %d", code)
+	}
+	return nil
+}
+
 func (c *Client) UpsertHost(host Host) error {
 	_, code, err := c.do("POST", "/api/push/hosts", host)
 	if err != nil {
@@ -153,3 +219,38 @@ func (c *Client) PushBulk(results []Result) error {
 	}
 	return nil
 }
+
+// ListHosts returns all hosts known to TinyMon.
+func (c *Client) ListHosts() ([]Host, error) {
+	var hosts []Host
+	if err := c.list("/api/push/hosts", &hosts); err != nil {
+		return nil, fmt.Errorf("list hosts: %w", err)
+	}
+	return hosts, nil
+}
+
+// ListChecks returns all checks known to TinyMon.
+func (c *Client) ListChecks() ([]Check, error) {
+	var checks []Check
+	if err := c.list("/api/push/checks", &checks); err != nil {
+		return nil, fmt.Errorf("list checks: %w", err)
+	}
+	return checks, nil
+}
+
+// list GETs path and decodes the response, a JSON array of the objects
+// pushed to path, into v. Anything else is an error, so callers that delete
+// what isn't listed never act on a response they don't understand.
+func (c *Client) list(path string, v interface{}) error {
+	body, code, err := c.do("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	if code != 200 {
+		return fmt.Errorf("unexpected status %d", code)
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		return fmt.Errorf("GET %s: expected a JSON array, the TinyMon push API may be too old", path)
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,59 @@
+package tinymon
+
+import "sort"
+
+// Diff returns the operations that turn actual into desired, using the same
+// actions as DryRun. Results are not compared, they change on every push.
+// Hosts in desired.Kept are left as they are.
+func Diff(desired, actual State) []Operation {
+	var ops []Operation
+
+	actualHosts := make(map[string]Host, len(actual.Hosts))
+	for _, h := range actual.Hosts {
+		actualHosts[h.Address] = h
+	}
+	desiredHosts := make(map[string]bool, len(desired.Hosts))
+	kept := make(map[string]bool, len(desired.Kept))
+	for _, addr := range desired.Kept {
+		kept[addr] = true
+	}
+	for _, h := range desired.Hosts {
+		desiredHosts[h.Address] = true
+		old, exists := actualHosts[h.Address]
+		if !exists {
+			ops = append(ops, Operation{Action: "create_host", Address: h.Address, Changes: diffFields(nil, h, false)})
+		} else if changes := diffFields(old, h, true); len(changes) > 0 {
+			ops = append(ops, Operation{Action: "update_host", Address: h.Address, Changes: changes})
+		}
+	}
+	for _, h := range actual.Hosts {
+		if !desiredHosts[h.Address] && !kept[h.Address] {
+			ops = append(ops, Operation{Action: "delete_host", Address: h.Address})
+		}
+	}
+
+	actualChecks := make(map[string]Check, len(actual.Checks))
+	for _, c := range actual.Checks {
+		actualChecks[c.HostAddress+"/"+checkKey(c)] = c
+	}
+	desiredChecks := make(map[string]bool, len(desired.Checks))
+	for _, c := range desired.Checks {
+		key := c.HostAddress + "/" + checkKey(c)
+		desiredChecks[key] = true
+		old, exists := actualChecks[key]
+		if !exists {
+			ops = append(ops, Operation{Action: "create_check", Address: c.HostAddress, CheckType: c.Type, Changes: diffFields(nil, c, false)})
+		} else if changes := diffFields(old, c, true); len(changes) > 0 {
+			ops = append(ops, Operation{Action: "update_check", Address: c.HostAddress, CheckType: c.Type, Changes: changes})
+		}
+	}
+	for _, c := range actual.Checks {
+		// Checks of deleted hosts are deleted with the host.
+		if !desiredChecks[c.HostAddress+"/"+checkKey(c)] && desiredHosts[c.HostAddress] {
+			ops = append(ops, Operation{Action: "delete_check", Address: c.HostAddress, CheckType: c.Type})
+		}
+	}
+
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Address < ops[j].Address })
+	return ops
+}
@@ -0,0 +1,288 @@
+package tinymon
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// maxDryRunOperations caps the number of operations kept for the HTTP endpoint.
+const maxDryRunOperations = 1000
+
+// Operation is a mutation the operator would have sent to TinyMon.
+type Operation struct {
+	Time      time.Time         `json:"time"`
+	Action    string            `json:"action"`
+	Address   string            `json:"address"`
+	CheckType string            `json:"check_type,omitempty"`
+	Changes   map[string]Change `json:"changes,omitempty"`
+}
+
+// Change is the old and new value of a field. Old is nil for new objects.
+type Change struct {
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// DryRun implements API without calling TinyMon. It keeps the state the
+// operator intends to create and logs every operation that would change it.
+// Repeated identical upserts and pushes are not recorded.
+type DryRun struct {
+	log logr.Logger
+
+	mu      sync.Mutex
+	hosts   map[string]Host
+	checks  map[string]Check  // address/type/config -> check
+	results map[string]Result // address/type -> result
+	deleted map[string]bool   // addresses deleted without being known
+	kept    map[string]bool   // addresses kept by the deletion policy
+	ops     []Operation
+}
+
+func NewDryRun(log logr.Logger) *DryRun {
+	return &DryRun{
+		log:     log,
+		hosts:   make(map[string]Host),
+		checks:  make(map[string]Check),
+		results: make(map[string]Result),
+		deleted: make(map[string]bool),
+		kept:    make(map[string]bool),
+	}
+}
+
+func (d *DryRun) UpsertHost(host Host) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	old, exists := d.hosts[host.Address]
+	d.hosts[host.Address] = host
+	delete(d.deleted, host.Address)
+	action := "create_host"
+	if exists {
+		action = "update_host"
+	}
+	changes := diffFields(old, host, exists)
+	if len(changes) > 0 {
+		d.record(Operation{Action: action, Address: host.Address, Changes: changes})
+	}
+	return nil
+}
+
+func (d *DryRun) DeleteHost(address string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.hosts[address]; !ok {
+		// The host may exist in TinyMon from before the dry-run started, so
+		// the deletion is recorded, but only once: controllers delete on
+		// every reconcile of an object that isn't enabled.
+		if !d.deleted[address] {
+			d.deleted[address] = true
+			d.record(Operation{Action: "delete_host_if_exists", Address: address})
+		}
+		return nil
+	}
+	delete(d.hosts, address)
+	for key, c := range d.checks {
+		if c.HostAddress == address {
+			delete(d.checks, key)
+		}
+	}
+	for key, r := range d.results {
+		if r.HostAddress == address {
+			delete(d.results, key)
+		}
+	}
+	d.record(Operation{Action: "delete_host", Address: address})
+	return nil
+}
+
+// KeepHost records that the host at address is left in TinyMon by the
+// deletion policy, so it isn't mistaken for a host no object asks for.
+func (d *DryRun) KeepHost(address string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.kept[address] = true
+}
+
+func (d *DryRun) UpsertCheck(check Check) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := check.HostAddress + "/" + checkKey(check)
+	old, exists := d.checks[key]
+	d.checks[key] = check
+	action := "create_check"
+	if exists {
+		action = "update_check"
+	}
+	changes := diffFields(old, check, exists)
+	if len(changes) > 0 {
+		d.record(Operation{Action: action, Address: check.HostAddress, CheckType: check.Type, Changes: changes})
+	}
+	return nil
+}
+
+func (d *DryRun) DeleteCheck(hostAddress, checkType string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	found := false
+	for key, c := range d.checks {
+		if c.HostAddress == hostAddress && c.Type == checkType {
+			delete(d.checks, key)
+			found = true
+		}
+	}
+	delete(d.results, hostAddress+"/"+checkType)
+	if found {
+		d.record(Operation{Action: "delete_check", Address: hostAddress, CheckType: checkType})
+	}
+	return nil
+}
+
+func (d *DryRun) PushResult(result Result) error {
+	return d.PushBulk([]Result{result})
+}
+
+// PushBulk records results whose status or message changed. Values alone
+// change on nearly every push and are not recorded.
+func (d *DryRun) PushBulk(results []Result) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, res := range results {
+		key := res.HostAddress + "/" + res.CheckType
+		old, exists := d.results[key]
+		d.results[key] = res
+		if exists && old.Status == res.Status && old.Message == res.Message {
+			continue
+		}
+		changes := map[string]Change{"status": {New: res.Status}, "message": {New: res.Message}}
+		if exists {
+			changes = map[string]Change{
+				"status":  {Old: old.Status, New: res.Status},
+				"message": {Old: old.Message, New: res.Message},
+			}
+		}
+		d.record(Operation{Action: "push_result", Address: res.HostAddress, CheckType: res.CheckType, Changes: changes})
+	}
+	return nil
+}
+
+// record appends an operation and logs it. Callers must hold d.mu.
+func (d *DryRun) record(op Operation) {
+	op.Time = time.Now()
+	d.ops = append(d.ops, op)
+	if len(d.ops) > maxDryRunOperations {
+		d.ops = d.ops[len(d.ops)-maxDryRunOperations:]
+	}
+	log := d.log
+	if op.Action == "delete_host_if_exists" {
+		log = log.V(1)
+	}
+	log.Info("dry-run: skipping TinyMon mutation", "action", op.Action, "address", op.Address, "checkType", op.CheckType, "changes", op.Changes)
+}
+
+// State is the full set of hosts, checks and results the operator intends
+// TinyMon to hold. Kept are the addresses of hosts the operator leaves as
+// they are because of the deletion policy.
+type State struct {
+	Hosts   []Host   `json:"hosts"`
+	Checks  []Check  `json:"checks"`
+	Results []Result `json:"results"`
+	Kept    []string `json:"kept,omitempty"`
+}
+
+// Snapshot returns the intended state, sorted by address and check type.
+func (d *DryRun) Snapshot() State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st := State{Hosts: []Host{}, Checks: []Check{}, Results: []Result{}}
+	for _, h := range d.hosts {
+		st.Hosts = append(st.Hosts, h)
+	}
+	for _, c := range d.checks {
+		st.Checks = append(st.Checks, c)
+	}
+	for _, r := range d.results {
+		st.Results = append(st.Results, r)
+	}
+	for addr := range d.kept {
+		if _, ok := d.hosts[addr]; !ok {
+			st.Kept = append(st.Kept, addr)
+		}
+	}
+	sort.Strings(st.Kept)
+	sort.Slice(st.Hosts, func(i, j int) bool { return st.Hosts[i].Address < st.Hosts[j].Address })
+	sort.Slice(st.Checks, func(i, j int) bool {
+		if st.Checks[i].HostAddress != st.Checks[j].HostAddress {
+			return st.Checks[i].HostAddress < st.Checks[j].HostAddress
+		}
+		return checkKey(st.Checks[i]) < checkKey(st.Checks[j])
+	})
+	sort.Slice(st.Results, func(i, j int) bool {
+		if st.Results[i].HostAddress != st.Results[j].HostAddress {
+			return st.Results[i].HostAddress < st.Results[j].HostAddress
+		}
+		return st.Results[i].CheckType < st.Results[j].CheckType
+	})
+	return st
+}
+
+// checkKey identifies a check of a host by type and config; a host can have
+// several checks of the same type, e.g. one http check per ingress rule.
+func checkKey(c Check) string {
+	cfg, _ := json.Marshal(c.Config)
+	return c.Type + "/" + string(cfg)
+}
+
+// dryRunReport is served by the HTTP endpoint.
+type dryRunReport struct {
+	Hosts      int         `json:"hosts"`
+	Checks     int         `json:"checks"`
+	Operations []Operation `json:"operations"`
+}
+
+// ServeHTTP serves the intended operations as JSON, oldest first.
+func (d *DryRun) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	d.mu.Lock()
+	report := dryRunReport{
+		Hosts:      len(d.hosts),
+		Checks:     len(d.checks),
+		Operations: append([]Operation(nil), d.ops...),
+	}
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+}
+
+// diffFields compares the JSON fields of two structs of the same type. If
+// exists is false, all non-empty fields of b are returned as new values.
+func diffFields(a, b interface{}, exists bool) map[string]Change {
+	am, bm := toMap(a), toMap(b)
+	changes := make(map[string]Change)
+	for k, v := range bm {
+		if !exists {
+			changes[k] = Change{New: v}
+		} else if !reflect.DeepEqual(am[k], v) {
+			changes[k] = Change{Old: am[k], New: v}
+		}
+	}
+	for k, v := range am {
+		if _, ok := bm[k]; !ok && exists {
+			changes[k] = Change{Old: v}
+		}
+	}
+	return changes
+}
+
+func toMap(v interface{}) map[string]interface{} {
+	data, _ := json.Marshal(v)
+	m := make(map[string]interface{})
+	_ = json.Unmarshal(data, &m)
+	return m
+}
@@ -0,0 +1,82 @@
+package tinymon
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AddressMapping maps host addresses starting with Old to addresses starting
+// with New, e.g. k8s://old-cluster/ to k8s://new-cluster/.
+type AddressMapping struct {
+	Old string
+	New string
+}
+
+// ParseAddressMapping parses "old=new".
+func ParseAddressMapping(s string) (AddressMapping, error) {
+	oldPrefix, newPrefix, ok := strings.Cut(s, "=")
+	if !ok || oldPrefix == "" || newPrefix == "" {
+		return AddressMapping{}, fmt.Errorf("invalid address mapping %q, expected old-prefix=new-prefix", s)
+	}
+	if oldPrefix == newPrefix {
+		return AddressMapping{}, fmt.Errorf("invalid address mapping %q, prefixes are equal", s)
+	}
+	return AddressMapping{Old: oldPrefix, New: newPrefix}, nil
+}
+
+// Migration moves a host and its checks to a new address.
+type Migration struct {
+	Host   Host
+	From   string
+	Checks []Check
+}
+
+// PlanMigrations returns a migration for every host whose address matches
+// one of the mappings. The first matching mapping wins. Hosts that already
+// have a new address don't match, so running a migration again only picks
+// up hosts left over by an interrupted run.
+func PlanMigrations(hosts []Host, checks []Check, mappings []AddressMapping) []Migration {
+	byHost := make(map[string][]Check)
+	for _, c := range checks {
+		byHost[c.HostAddress] = append(byHost[c.HostAddress], c)
+	}
+
+	var migrations []Migration
+	for _, h := range hosts {
+		for _, m := range mappings {
+			if !strings.HasPrefix(h.Address, m.Old) {
+				continue
+			}
+			// With a new prefix that extends the old one, e.g.
+			// k8s://prod/ to k8s://prod/eu/, migrated hosts match again.
+			if strings.HasPrefix(m.New, m.Old) && strings.HasPrefix(h.Address, m.New) {
+				break
+			}
+			mig := Migration{Host: h, From: h.Address}
+			mig.Host.Address = m.New + strings.TrimPrefix(h.Address, m.Old)
+			for _, c := range byHost[h.Address] {
+				c.HostAddress = mig.Host.Address
+				mig.Checks = append(mig.Checks, c)
+			}
+			migrations = append(migrations, mig)
+			break
+		}
+	}
+	return migrations
+}
+
+// Migrate copies the host and its checks to the new address and then
+// deletes the old host. TinyMon's push API can't rename hosts, so results
+// recorded for the old host are not carried over. All calls are upserts or
+// deletes, so a failed migration can be run again.
+func Migrate(api API, m Migration) error {
+	if err := api.UpsertHost(m.Host); err != nil {
+		return err
+	}
+	for _, c := range m.Checks {
+		if err := api.UpsertCheck(c); err != nil {
+			return err
+		}
+	}
+	return api.DeleteHost(m.From)
+}
@@ -0,0 +1,115 @@
+package tinymon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAddressMapping(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    AddressMapping
+		wantErr bool
+	}{
+		{in: "k8s://old/=k8s://new/", want: AddressMapping{Old: "k8s://old/", New: "k8s://new/"}},
+		{in: "k8s://old/", wantErr: true},
+		{in: "=k8s://new/", wantErr: true},
+		{in: "k8s://old/=", wantErr: true},
+		{in: "k8s://old/=k8s://old/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseAddressMapping(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAddressMapping(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAddressMapping(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanMigrations(t *testing.T) {
+	checks := []Check{
+		{HostAddress: "k8s://prod/deployment/shop/web", Type: "status"},
+		{HostAddress: "k8s://prod/ingress/shop/web", Type: "http"},
+		{HostAddress: "k8s://prod/ingress/shop/web", Type: "certificate"},
+	}
+	tests := []struct {
+		name     string
+		hosts    []string
+		mappings []AddressMapping
+		want     map[string]string // from -> to
+	}{
+		{
+			name:     "rename cluster",
+			hosts:    []string{"k8s://prod/deployment/shop/web", "k8s://prod/ingress/shop/web", "k8s://staging/deployment/shop/web"},
+			mappings: []AddressMapping{{Old: "k8s://prod/", New: "k8s://prod-eu/"}},
+			want: map[string]string{
+				"k8s://prod/deployment/shop/web": "k8s://prod-eu/deployment/shop/web",
+				"k8s://prod/ingress/shop/web":    "k8s://prod-eu/ingress/shop/web",
+			},
+		},
+		{
+			name:     "already migrated",
+			hosts:    []string{"k8s://prod-eu/deployment/shop/web"},
+			mappings: []AddressMapping{{Old: "k8s://prod/", New: "k8s://prod-eu/"}},
+			want:     map[string]string{},
+		},
+		{
+			name:     "prefix-extending mapping",
+			hosts:    []string{"k8s://prod/deployment/shop/web", "k8s://prod/eu/deployment/shop/web"},
+			mappings: []AddressMapping{{Old: "k8s://prod/", New: "k8s://prod/eu/"}},
+			want: map[string]string{
+				"k8s://prod/deployment/shop/web": "k8s://prod/eu/deployment/shop/web",
+			},
+		},
+		{
+			name:  "first matching mapping wins",
+			hosts: []string{"k8s://prod/deployment/shop/web", "k8s://prod/ingress/shop/web"},
+			mappings: []AddressMapping{
+				{Old: "k8s://prod/deployment/", New: "k8s://apps/deployment/"},
+				{Old: "k8s://prod/", New: "k8s://prod-eu/"},
+			},
+			want: map[string]string{
+				"k8s://prod/deployment/shop/web": "k8s://apps/deployment/shop/web",
+				"k8s://prod/ingress/shop/web":    "k8s://prod-eu/ingress/shop/web",
+			},
+		},
+		{
+			name:     "no match",
+			hosts:    []string{"k8s://staging/deployment/shop/web"},
+			mappings: []AddressMapping{{Old: "k8s://prod/", New: "k8s://prod-eu/"}},
+			want:     map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts := make([]Host, 0, len(tt.hosts))
+			for _, addr := range tt.hosts {
+				hosts = append(hosts, Host{Name: "web", Address: addr, Enabled: 1})
+			}
+			got := make(map[string]string)
+			for _, m := range PlanMigrations(hosts, checks, tt.mappings) {
+				got[m.From] = m.Host.Address
+				if m.Host.Name != "web" || m.Host.Enabled != 1 {
+					t.Errorf("host %s lost its fields: %+v", m.From, m.Host)
+				}
+				var wantChecks []Check
+				for _, c := range checks {
+					if c.HostAddress == m.From {
+						c.HostAddress = m.Host.Address
+						wantChecks = append(wantChecks, c)
+					}
+				}
+				if !reflect.DeepEqual(m.Checks, wantChecks) {
+					t.Errorf("checks of %s = %+v, want %+v", m.From, m.Checks, wantChecks)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanMigrations() moves %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,24 +1,33 @@
 package main
 
 import (
-	"context"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	tinymonv1alpha1 "github.com/unclesamwk/tinymon-operator/api/v1alpha1"
 	"github.com/unclesamwk/tinymon-operator/internal/controller"
+	"github.com/unclesamwk/tinymon-operator/internal/debug"
+	"github.com/unclesamwk/tinymon-operator/internal/health"
+	"github.com/unclesamwk/tinymon-operator/internal/remote"
+	"github.com/unclesamwk/tinymon-operator/internal/shard"
 	"github.com/unclesamwk/tinymon-operator/internal/tinymon"
 
 	k8upv1 "github.com/k8up-io/k8up/v2/api/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/rest"
 	// metricsv1beta1 removed from scheme — metrics are fetched via REST client in node controller
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -29,19 +38,59 @@ var scheme = runtime.NewScheme()
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(k8upv1.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
+	utilruntime.Must(tinymonv1alpha1.AddToScheme(scheme))
 	// metricsv1beta1 intentionally not added to scheme — causes watch errors on clusters
 	// where metrics-server doesn't support watch. Metrics are fetched via direct REST calls.
 }
 
 func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	var metricsAddr string
 	var probeAddr string
+	var eventInterval time.Duration
+	var writeStatus bool
+	var dryRun bool
+	var readyFailureWindow time.Duration
+	var probeInterval time.Duration
+	var reconcileTimeout time.Duration
+	var debugAddr string
+	var deletionPolicyName string
+	var deletionRetention time.Duration
+	var configPath string
+	var namespaceCredentials bool
+	var remoteNamespace string
+	var shardNamespace string
+	var shardGroup string
+	var metadataOnly bool
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.DurationVar(&readyFailureWindow, "ready-failure-window", 5*time.Minute, "Report not ready once all TinyMon calls have been failing for this long.")
+	flag.DurationVar(&probeInterval, "tinymon-probe-interval", 0, "Interval of an authenticated probe request to TinyMon for the readiness check (0 disables the probe).")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 10*time.Minute, "Report not alive once a single reconcile has been running for this long.")
+	flag.StringVar(&debugAddr, "debug-bind-address", "", "The address the debug endpoint listing managed hosts binds to (empty disables it). Requires DEBUG_TOKEN.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Compute hosts, checks and results without sending any mutation to TinyMon. Intended operations are logged and served on /dry-run of the metrics endpoint.")
+	flag.BoolVar(&writeStatus, "write-status", false, "Write sync status annotations (tinymon.io/address, last-sync, last-status, sync-error) back onto monitored objects.")
+	flag.StringVar(&configPath, "config", "", "Path of a YAML file with templates per kind, label mappings, hysteresis defaults and the heartbeat, see the README.")
+	flag.StringVar(&deletionPolicyName, "deletion-policy", "delete", "What happens to the host when monitoring is turned off or the object is deleted: delete, disable (keep the history) or retain. Overridden per object by tinymon.io/deletion-policy.")
+	flag.DurationVar(&deletionRetention, "deletion-retention", 0, "Delete hosts disabled by the disable deletion policy after this long (0 keeps them).")
+	flag.BoolVar(&namespaceCredentials, "namespace-credentials", false, "Send the objects of Namespaces annotated with tinymon.io/credentials-secret to the TinyMon in that Secret. Requires reading Secrets.")
+	flag.StringVar(&remoteNamespace, "remote-clusters-namespace", "", "Namespace of the Secrets labeled tinymon.io/remote-cluster with the kubeconfigs of remote clusters to monitor (empty disables remote clusters).")
+	flag.StringVar(&shardNamespace, "shard-namespace", "", "Namespace of the Leases the replicas of --shard-group share the monitored objects by (empty disables sharding, the operator reconciles every object).")
+	flag.StringVar(&shardGroup, "shard-group", "tinymon-operator", "Name of the group of replicas sharing the monitored objects, unique per --shard-namespace.")
+	flag.BoolVar(&metadataOnly, "metadata-only-cache", false, "Cache only the metadata of Deployments, PVCs and Ingresses and read the synced ones in full from the API server, to save memory on large clusters.")
+	flag.DurationVar(&eventInterval, "event-interval", 10*time.Minute, "Minimum interval between repeated warning events of the same reason on an object.")
 
 	opts := zap.Options{Development: false}
 	opts.BindFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), commandUsage, "\nFlags:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
@@ -65,14 +114,65 @@ func main() {
 		os.Exit(1)
 	}
 
-	client := tinymon.NewClient(tinymonURL, apiKey)
+	deletionPolicy, err := controller.ParseDeletionPolicy(deletionPolicyName)
+	if err != nil {
+		log.Error(err, "invalid --deletion-policy")
+		os.Exit(1)
+	}
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		log.Error(err, "unable to load --config")
+		os.Exit(1)
+	}
+
+	tmClient := tinymon.NewClient(tinymonURL, apiKey)
+	var tm tinymon.API = tmClient
+	metricsOpts := metricsserver.Options{BindAddress: metricsAddr}
+	if dryRun {
+		dry := tinymon.NewDryRun(ctrl.Log.WithName("dry-run"))
+		tm = dry
+		metricsOpts.ExtraHandlers = map[string]http.Handler{"/dry-run": dry}
+		log.Info("dry-run mode enabled, no changes will be sent to TinyMon")
+		if writeStatus {
+			// Status annotations would claim syncs that never happened.
+			log.Info("ignoring --write-status in dry-run mode")
+			writeStatus = false
+		}
+	}
+
+	var debugState *debug.State
+	if debugAddr != "" {
+		if os.Getenv("DEBUG_TOKEN") == "" {
+			log.Error(nil, "DEBUG_TOKEN environment variable is required with --debug-bind-address")
+			os.Exit(1)
+		}
+		debugState = debug.NewState()
+		tm = debugState.Wrap(tm)
+	}
 
 	restConfig := ctrl.GetConfigOrDie()
-	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+	mgrOpts := ctrl.Options{
 		Scheme:                 scheme,
 		HealthProbeBindAddress: probeAddr,
-		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
-	})
+		Metrics:                metricsOpts,
+	}
+	mgrOpts.Cache.DefaultTransform = controller.CacheTransform
+	mgrOpts.Cache.ByObject = map[client.Object]cache.ByObject{}
+	if remoteNamespace != "" {
+		// Only the remote cluster Secrets are watched, not every Secret.
+		mgrOpts.Cache.ByObject[&corev1.Secret{}] = cache.ByObject{
+			Namespaces: map[string]cache.Config{remoteNamespace: {}},
+			Label:      remote.Selector(),
+		}
+	}
+	if shardNamespace != "" {
+		mgrOpts.Cache.ByObject[&coordinationv1.Lease{}] = cache.ByObject{
+			Namespaces: map[string]cache.Config{shardNamespace: {}},
+			Label:      shard.Selector(shardGroup),
+		}
+	}
+	mgr, err := ctrl.NewManager(restConfig, mgrOpts)
 	if err != nil {
 		log.Error(err, "unable to start manager")
 		os.Exit(1)
@@ -84,90 +184,113 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Core controllers — always available
-	if err := controller.SetupNodeReconciler(mgr, client, clusterName, clientset); err != nil {
-		log.Error(err, "unable to setup node controller")
-		os.Exit(1)
+	var credentials *controller.Credentials
+	var lister controller.HostLister = tmClient
+	var wrap func(tinymon.API) tinymon.API
+	if debugState != nil {
+		wrap = debugState.Wrap
 	}
-	if err := controller.SetupDeploymentReconciler(mgr, client, clusterName); err != nil {
-		log.Error(err, "unable to setup deployment controller")
-		os.Exit(1)
+	if namespaceCredentials {
+		if dryRun {
+			log.Info("ignoring --namespace-credentials in dry-run mode, all objects are recorded as if sent to TINYMON_URL")
+		} else {
+			credentials = controller.NewCredentials(tmClient, mgr.GetAPIReader(), wrap)
+			lister = credentials
+		}
+	}
+
+	var shards *shard.Ring
+	if shardNamespace != "" {
+		// The hostname is the Pod name, unique among the replicas.
+		identity, err := os.Hostname()
+		if err != nil {
+			log.Error(err, "unable to determine the shard identity")
+			os.Exit(1)
+		}
+		shards = shard.New(mgr, shardNamespace, shardGroup, identity)
+		if err := mgr.Add(shards); err != nil {
+			log.Error(err, "unable to set up sharding")
+			os.Exit(1)
+		}
 	}
-	if err := controller.SetupIngressReconciler(mgr, client, clusterName); err != nil {
-		log.Error(err, "unable to setup ingress controller")
+
+	ctrlOpts := controller.Options{
+		Cluster:        clusterName,
+		Events:         controller.NewNotifier(mgr.GetEventRecorder("tinymon-operator"), eventInterval),
+		WriteStatus:    writeStatus,
+		DryRun:         dryRun,
+		Workers:        health.NewWorkers(reconcileTimeout),
+		Debug:          debugState,
+		DeletionPolicy: deletionPolicy,
+		Disabled:       controller.NewDisabledHosts(lister),
+		RemovedChecks:  controller.NewRemovedChecks(),
+		Credentials:    credentials,
+		Shards:         shards,
+		MetadataOnly:   metadataOnly,
+	}
+	if err := cfg.apply(&ctrlOpts); err != nil {
+		log.Error(err, "invalid --config")
 		os.Exit(1)
 	}
-	if err := controller.SetupPVCReconciler(mgr, client, clusterName); err != nil {
-		log.Error(err, "unable to setup pvc controller")
+
+	setup := clusterSetup{
+		tm:            tm,
+		tmClient:      tmClient,
+		opts:          ctrlOpts,
+		credentials:   credentials != nil,
+		wrap:          wrap,
+		eventInterval: eventInterval,
+	}
+	if !dryRun {
+		setup.retention = deletionRetention
+	}
+	if err := setup.setupControllers(mgr, ctrlOpts, clientset); err != nil {
+		log.Error(err, "unable to set up controllers")
 		os.Exit(1)
 	}
 
-	// Optional controllers — registered if CRDs are available, or watched for in the background
-	k8upGV := schema.GroupVersion{Group: "k8up.io", Version: "v1"}
-	if apiAvailable(restConfig, k8upGV) {
-		if err := controller.SetupBackupReconciler(mgr, client, clusterName); err != nil {
-			log.Error(err, "unable to setup backup controller")
+	if remoteNamespace != "" {
+		if err := mgr.Add(remote.New(mgr, remoteNamespace, clusterName, setup.newRemote)); err != nil {
+			log.Error(err, "unable to set up remote clusters")
+			os.Exit(1)
+		}
+	}
+
+	if debugState != nil {
+		if err := mgr.Add(&debug.Server{Addr: debugAddr, Token: os.Getenv("DEBUG_TOKEN"), State: debugState}); err != nil {
+			log.Error(err, "unable to set up debug endpoint")
+			os.Exit(1)
+		}
+	}
+
+	if probeInterval > 0 {
+		if err := mgr.Add(&health.Probe{Client: tmClient, Interval: probeInterval}); err != nil {
+			log.Error(err, "unable to set up TinyMon probe")
 			os.Exit(1)
 		}
-		log.Info("backup controller enabled (k8up.io/v1 available)")
-	} else {
-		log.Info("backup controller skipped (k8up.io/v1 CRDs not installed), watching for availability...")
-		go watchForAPI(mgr, restConfig, k8upGV, func() error {
-			return controller.SetupBackupReconciler(mgr, client, clusterName)
-		})
 	}
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		log.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
+	if err := mgr.AddHealthzCheck("workers", ctrlOpts.Workers.Check); err != nil {
+		log.Error(err, "unable to set up worker health check")
+		os.Exit(1)
+	}
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		log.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	tinymonCheck := &health.TinyMonChecker{Client: tmClient, Window: readyFailureWindow}
+	if err := mgr.AddReadyzCheck("tinymon", tinymonCheck.Check); err != nil {
+		log.Error(err, "unable to set up TinyMon ready check")
+		os.Exit(1)
+	}
 
-	log.Info("starting manager", "tinymonURL", tinymonURL, "cluster", clusterName)
+	log.Info("starting manager", "tinymonURL", tinymonURL, "cluster", clusterName, "dryRun", dryRun, "sharded", shards != nil)
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		log.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
-
-// apiAvailable checks if a GroupVersion is registered in the cluster's API server.
-func apiAvailable(cfg *rest.Config, gv schema.GroupVersion) bool {
-	disc, err := discovery.NewDiscoveryClientForConfig(cfg)
-	if err != nil {
-		return false
-	}
-	resources, err := disc.ServerResourcesForGroupVersion(gv.String())
-	return err == nil && resources != nil
-}
-
-// watchForAPI polls the API server until the given GroupVersion becomes available,
-// then registers the controller via setupFn. This allows optional controllers
-// (e.g. K8up backup) to start dynamically when their CRDs are installed later.
-func watchForAPI(mgr ctrl.Manager, cfg *rest.Config, gv schema.GroupVersion, setupFn func() error) {
-	log := ctrl.Log.WithName("api-watch").WithValues("groupVersion", gv.String())
-	ctx := context.Background()
-
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			if !apiAvailable(cfg, gv) {
-				continue
-			}
-			log.Info("API became available, registering controller")
-			if err := setupFn(); err != nil {
-				log.Error(err, "failed to setup controller after API became available")
-				return
-			}
-			log.Info("controller registered successfully")
-			return
-		}
-	}
-}